@@ -0,0 +1,103 @@
+package socketioclient
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Emit waiting for the server to acknowledge it, like emitWithAck of the JS
+client. Returns the ack arguments. Gives up when ctx is done or the
+connection is lost, a late ack is then ignored
+*/
+func (c *Client) EmitWithAck(ctx context.Context, method string, args interface{}) ([]json.RawMessage, error) {
+	waiter := c.acks.add(method)
+	defer c.acks.remove(waiter.id)
+
+	// an ack of the lost connection never comes
+	closed := c.Context().Done()
+	if err := c.emit(ctx, method, args, waiter.id, queueBlock, false); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-waiter.reply:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-closed:
+		return nil, ErrorSocketClosed
+	}
+}
+
+type ackWaiter struct {
+	id     int
+	event  string
+	sentAt time.Time
+	// receives the ack arguments once
+	reply chan []json.RawMessage
+}
+
+/*
+*
+Emits waiting for their ack, by packet id
+*/
+type ackTable struct {
+	lock    sync.Mutex
+	lastID  int
+	waiters map[int]*ackWaiter
+}
+
+/*
+*
+Register an emit of event, ids start at 1 as plain emits ask for ack 0
+*/
+func (t *ackTable) add(event string) *ackWaiter {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.waiters == nil {
+		t.waiters = make(map[int]*ackWaiter)
+	}
+	t.lastID++
+	if t.lastID <= 0 {
+		t.lastID = 1
+	}
+	w := &ackWaiter{id: t.lastID, event: event, sentAt: time.Now(), reply: make(chan []json.RawMessage, 1)}
+	t.waiters[w.id] = w
+	return w
+}
+
+func (t *ackTable) remove(id int) {
+	t.lock.Lock()
+	delete(t.waiters, id)
+	t.lock.Unlock()
+}
+
+/*
+*
+Take the waiter of id, nil if nobody waits for it
+*/
+func (t *ackTable) take(id int) *ackWaiter {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	w := t.waiters[id]
+	delete(t.waiters, id)
+	return w
+}
+
+/*
+*
+Hand a server ack to the emit waiting for it and record the ack latency
+*/
+func (c *Channel) processAck(msg *protocol.Message) {
+	w := c.acks.take(msg.SocketEvent.ID)
+	if w == nil {
+		return
+	}
+	c.stats().ObserveHistogram(MetricAckLatency, time.Since(w.sentAt).Seconds(), eventLabels(w.event))
+	w.reply <- msg.SocketEvent.Args
+}
@@ -0,0 +1,144 @@
+package socketioclient
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type histogramMetrics struct {
+	noopMetrics
+	lock         sync.Mutex
+	observations map[string][]float64
+}
+
+func (m *histogramMetrics) ObserveHistogram(name string, value float64, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.observations == nil {
+		m.observations = make(map[string][]float64)
+	}
+	m.observations[name] = append(m.observations[name], value)
+}
+
+func (m *histogramMetrics) observed(name string) []float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.observations[name]
+}
+
+/*
+*
+Wait for a packet in the bulk lane
+*/
+func nextQueued(t *testing.T, c *Client) string {
+	t.Helper()
+	select {
+	case f := <-c.out:
+		defer f.release()
+		return string(f.data)
+	case <-time.After(time.Second):
+		t.Fatal("nothing queued")
+		return ""
+	}
+}
+
+func TestEmitWithAck(t *testing.T) {
+	metrics := &histogramMetrics{}
+	c := newTestClient(ConConf{Metrics: metrics})
+	type result struct {
+		args []json.RawMessage
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		args, err := c.EmitWithAck(context.Background(), "ask", 1)
+		done <- result{args, err}
+	}()
+	if got := nextQueued(t, c); got != `42/,1["ask",1]` {
+		t.Fatalf("queued %s", got)
+	}
+	// acks of other ids are ignored
+	receive(c, `43/,7["other"]`)
+	receive(c, `43/,1["ok",2]`)
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if len(r.args) != 2 || string(r.args[0]) != `"ok"` || string(r.args[1]) != `2` {
+			t.Fatalf("ack args = %s", r.args)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitWithAck still waiting after the ack")
+	}
+	if n := len(metrics.observed(MetricAckLatency)); n != 1 {
+		t.Fatalf("%d ack latencies recorded, want 1", n)
+	}
+}
+
+func TestEmitWithAckGivesUp(t *testing.T) {
+	c := newTestClient(ConConf{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.EmitWithAck(ctx, "ask", 1); err != context.DeadlineExceeded {
+		t.Fatalf("EmitWithAck = %v, want context.DeadlineExceeded", err)
+	}
+	if len(c.acks.waiters) != 0 {
+		t.Fatalf("%d waiters left after the timeout", len(c.acks.waiters))
+	}
+	// a late ack is ignored
+	receive(c, `43/,1["late"]`)
+
+	c.startSession()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.EmitWithAck(context.Background(), "ask", 2)
+		done <- err
+	}()
+	// the emit that timed out and this one
+	nextQueued(t, c)
+	nextQueued(t, c)
+	c.endSession()
+	select {
+	case err := <-done:
+		if err != ErrorSocketClosed {
+			t.Fatalf("EmitWithAck = %v, want ErrorSocketClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitWithAck still waiting after the connection was lost")
+	}
+}
+
+func TestAckNotBehindHandlers(t *testing.T) {
+	c := newTestClient(ConConf{})
+	conn := newFakeConn()
+	c.conn = conn
+	c.startSession()
+	defer c.endSession()
+	done := make(chan error, 1)
+	// the handler blocks the sequential dispatcher until its ack arrives
+	c.On("question", func(ctx context.Context, ch *Channel) {
+		_, err := c.EmitWithAck(ctx, "answer", 1)
+		done <- err
+	})
+	go inLoop(&c.Channel, &c.methods)
+	defer conn.Close()
+
+	conn.replies <- `42["question"]`
+	if got := nextQueued(t, c); got != `42/,1["answer",1]` {
+		t.Fatalf("queued %s", got)
+	}
+	conn.replies <- `431["ok"]`
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ack queued behind the handler waiting for it")
+	}
+}
@@ -0,0 +1,198 @@
+package socketioclient
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Catch-all listener, receives the event name and its raw arguments
+*/
+type AnyHandler func(event string, args []json.RawMessage)
+
+/*
+*
+Token returned when adding a listener, used to remove it again
+*/
+type Subscription uint64
+
+var subscriptionSeq atomic.Uint64
+
+func nextSubscription() Subscription {
+	return Subscription(subscriptionSeq.Add(1))
+}
+
+type anyListener struct {
+	sub Subscription
+	f   AnyHandler
+}
+
+type anyListeners struct {
+	lock     sync.RWMutex
+	incoming []anyListener
+	outgoing []anyListener
+}
+
+func addAny(list []anyListener, f AnyHandler, prepend bool) ([]anyListener, Subscription) {
+	l := anyListener{sub: nextSubscription(), f: f}
+	if prepend {
+		return append([]anyListener{l}, list...), l.sub
+	}
+	return append(list, l), l.sub
+}
+
+/*
+*
+Remove given subscriptions, or every listener when none given
+*/
+func removeAny(list []anyListener, subs []Subscription) []anyListener {
+	if len(subs) == 0 {
+		return nil
+	}
+	kept := make([]anyListener, 0, len(list))
+	for _, l := range list {
+		removed := false
+		for _, sub := range subs {
+			if l.sub == sub {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+/*
+*
+Call listeners in order, a panicking listener is reported to the handler
+error callback and does not stop the others
+*/
+func callAny(ctx context.Context, c *Channel, list []anyListener, event string, args []json.RawMessage) {
+	for _, l := range list {
+		c.safeRun(ctx, func() error {
+			l.f(event, args)
+			return nil
+		})
+	}
+}
+
+func (a *anyListeners) emitIncoming(ctx context.Context, c *Channel, p *Packet) {
+	a.lock.RLock()
+	list := a.incoming
+	a.lock.RUnlock()
+	callAny(ctx, c, list, p.SocketEvent.EventName, p.SocketEvent.Args)
+}
+
+/*
+*
+Prepare the outgoing listeners call for p. Arguments are taken as the
+middlewares left them, before the trace carrier is added
+*/
+func (a *anyListeners) prepareOutgoing(c *Channel, p *Packet) func(ctx context.Context) {
+	a.lock.RLock()
+	list := a.outgoing
+	a.lock.RUnlock()
+	if len(list) == 0 {
+		return func(context.Context) {}
+	}
+	event := p.SocketEvent.EventName
+	// an argument the codec fails on fails the send too, listeners are not called then
+	args, _ := protocol.EventArgs(p, parserCodec(c.parser()))
+	return func(ctx context.Context) {
+		callAny(ctx, c, list, event, args)
+	}
+}
+
+/*
+*
+JSON codec of the parser, nil for the default one
+*/
+func parserCodec(parser protocol.Parser) protocol.JSONCodec {
+	switch p := parser.(type) {
+	case protocol.JSONParser:
+		return p.Codec
+	case *protocol.JSONParser:
+		return p.Codec
+	case protocol.MsgpackParser:
+		return p.Codec
+	case *protocol.MsgpackParser:
+		return p.Codec
+	}
+	return nil
+}
+
+/*
+*
+Add listener called for every incoming event, whether it has a handler or not
+*/
+func (c *Client) OnAny(f AnyHandler) Subscription {
+	c.anyListeners.lock.Lock()
+	defer c.anyListeners.lock.Unlock()
+	var sub Subscription
+	c.anyListeners.incoming, sub = addAny(c.anyListeners.incoming, f, false)
+	return sub
+}
+
+/*
+*
+Add listener called for every incoming event before the other catch-all listeners
+*/
+func (c *Client) PrependAny(f AnyHandler) Subscription {
+	c.anyListeners.lock.Lock()
+	defer c.anyListeners.lock.Unlock()
+	var sub Subscription
+	c.anyListeners.incoming, sub = addAny(c.anyListeners.incoming, f, true)
+	return sub
+}
+
+/*
+*
+Remove given catch-all listeners, or all of them when called without arguments
+*/
+func (c *Client) OffAny(subs ...Subscription) {
+	c.anyListeners.lock.Lock()
+	c.anyListeners.incoming = removeAny(c.anyListeners.incoming, subs)
+	c.anyListeners.lock.Unlock()
+}
+
+/*
+*
+Add listener called for every emitted event once it is queued
+*/
+func (c *Client) OnAnyOutgoing(f AnyHandler) Subscription {
+	c.anyListeners.lock.Lock()
+	defer c.anyListeners.lock.Unlock()
+	var sub Subscription
+	c.anyListeners.outgoing, sub = addAny(c.anyListeners.outgoing, f, false)
+	return sub
+}
+
+/*
+*
+Add outgoing catch-all listener before the other ones
+*/
+func (c *Client) PrependAnyOutgoing(f AnyHandler) Subscription {
+	c.anyListeners.lock.Lock()
+	defer c.anyListeners.lock.Unlock()
+	var sub Subscription
+	c.anyListeners.outgoing, sub = addAny(c.anyListeners.outgoing, f, true)
+	return sub
+}
+
+/*
+*
+Remove given outgoing catch-all listeners, or all of them when called without arguments
+*/
+func (c *Client) OffAnyOutgoing(subs ...Subscription) {
+	c.anyListeners.lock.Lock()
+	c.anyListeners.outgoing = removeAny(c.anyListeners.outgoing, subs)
+	c.anyListeners.lock.Unlock()
+}
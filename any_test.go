@@ -0,0 +1,127 @@
+package socketioclient
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+const traceCarrier = `{"traceparent":"00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01"}`
+
+/*
+*
+Tracer carrying trace context in a trailing argument like the tracing
+package does, without its OpenTelemetry dependency
+*/
+type carrierTracer struct{}
+
+func (carrierTracer) StartEmit(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	msg.SocketEvent.ExtraArgs = append(msg.SocketEvent.ExtraArgs, json.RawMessage(traceCarrier))
+	return ctx, func(error) {}
+}
+
+func (carrierTracer) StartEvent(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	args := msg.SocketEvent.Args
+	if len(args) > 0 && string(args[len(args)-1]) == traceCarrier {
+		msg.SocketEvent.Args = args[:len(args)-1]
+	}
+	return ctx, func(error) {}
+}
+
+func TestOnAnyOrder(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var calls []string
+	c.OnAny(func(event string, args []json.RawMessage) { calls = append(calls, "any") })
+	c.PrependAny(func(event string, args []json.RawMessage) { calls = append(calls, "prepended") })
+	c.On("say", func(c *Channel) { calls = append(calls, "handler") })
+
+	receive(c, `42["say","hi"]`)
+
+	if want := []string{"prepended", "any", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestOnAnyWithoutHandler(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var event string
+	var args []json.RawMessage
+	c.OnAny(func(e string, a []json.RawMessage) { event, args = e, a })
+
+	receive(c, `42["say","hi",2]`)
+
+	if event != "say" || len(args) != 2 || string(args[1]) != "2" {
+		t.Fatalf("OnAny got %q %s", event, args)
+	}
+}
+
+func TestOffAny(t *testing.T) {
+	c := newTestClient(ConConf{})
+	calls := 0
+	sub := c.OnAny(func(string, []json.RawMessage) { calls++ })
+	c.OnAny(func(string, []json.RawMessage) { calls++ })
+	c.OffAny(sub)
+	receive(c, `42["say"]`)
+	if calls != 1 {
+		t.Fatalf("calls after OffAny(sub) = %d, want 1", calls)
+	}
+	c.OffAny()
+	receive(c, `42["say"]`)
+	if calls != 1 {
+		t.Fatalf("calls after OffAny() = %d, want 1", calls)
+	}
+}
+
+func TestOnAnyWithoutTraceCarrier(t *testing.T) {
+	c := newTestClient(ConConf{Tracer: carrierTracer{}})
+	var args []json.RawMessage
+	c.OnAny(func(e string, a []json.RawMessage) { args = a })
+
+	receive(c, `42["say","hi",`+traceCarrier+`]`)
+
+	if len(args) != 1 || string(args[0]) != `"hi"` {
+		t.Fatalf("OnAny args = %s, want the carrier stripped", args)
+	}
+}
+
+func TestOnAnyOutgoing(t *testing.T) {
+	c := newTestClient(ConConf{QueueSize: 1})
+	var events []string
+	c.OnAnyOutgoing(func(event string, args []json.RawMessage) {
+		events = append(events, event+" "+string(args[0]))
+	})
+
+	if err := c.TryEmit("first", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.TryEmit("second", 2); err != ErrorSocketOverflood {
+		t.Fatalf("TryEmit on full queue = %v", err)
+	}
+	if want := []string{"first 1"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("outgoing listener got %v, want %v", events, want)
+	}
+}
+
+func TestOnAnyOutgoingWithoutTraceCarrier(t *testing.T) {
+	c := newTestClient(ConConf{Tracer: carrierTracer{}})
+	var middlewareArgs []interface{}
+	c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+		middlewareArgs = p.SocketEvent.ExtraArgs
+		return next(ctx, p)
+	})
+	var args []json.RawMessage
+	c.OnAnyOutgoing(func(e string, a []json.RawMessage) { args = a })
+
+	if err := c.TryEmit("ping", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(middlewareArgs) != 0 || len(args) != 0 {
+		t.Fatalf("middleware got %v, listener got %s, want no argument", middlewareArgs, args)
+	}
+	if data := nextQueued(t, c); data != `42/,0["ping",`+traceCarrier+`]` {
+		t.Fatalf("sent %s, want the trace carrier", data)
+	}
+}
@@ -0,0 +1,98 @@
+// Codec benchmarks, a module of their own so the client does not depend on
+// goccy/go-json. Run with: go test -bench .
+
+package codec
+
+import (
+	"testing"
+
+	gojson "github.com/goccy/go-json"
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+type goccyCodec struct{}
+
+func (goccyCodec) Marshal(v interface{}) ([]byte, error) {
+	return gojson.Marshal(v)
+}
+
+func (goccyCodec) Unmarshal(data []byte, v interface{}) error {
+	return gojson.Unmarshal(data, v)
+}
+
+type nodeUsage struct {
+	NodeID    string            `json:"node_id"`
+	Upload    int64             `json:"upload"`
+	Download  int64             `json:"download"`
+	Online    bool              `json:"online"`
+	Load      float64           `json:"load"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+func trafficEvent() *protocol.Message {
+	usage := make([]nodeUsage, 20)
+	for i := range usage {
+		usage[i] = nodeUsage{
+			NodeID:    "node-0000" + string(rune('a'+i)),
+			Upload:    int64(i) * 1 << 20,
+			Download:  int64(i) * 3 << 20,
+			Online:    i%3 != 0,
+			Load:      float64(i) / 7,
+			Tags:      []string{"edge", "eu-west"},
+			Labels:    map[string]string{"plan": "pro", "region": "fra"},
+			Timestamp: 1731917526,
+		}
+	}
+	msg := &protocol.Message{
+		EngineIoType: protocol.EngineMessageTypeMessage,
+		SocketType:   protocol.SocketMessageTypeEvent,
+	}
+	msg.SocketEvent.NS = "proxy"
+	msg.SocketEvent.EventName = "node_traffic_usage"
+	msg.SocketEvent.EventContent = usage
+	return msg
+}
+
+var codecs = []struct {
+	name  string
+	codec protocol.JSONCodec
+}{
+	{"encoding/json", protocol.StdJSONCodec{}},
+	{"goccy/go-json", goccyCodec{}},
+}
+
+func BenchmarkParserEncode(b *testing.B) {
+	msg := trafficEvent()
+	for _, c := range codecs {
+		parser := protocol.JSONParser{Codec: c.codec}
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := parser.Encode(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParserDecode(b *testing.B) {
+	data, _, err := protocol.JSONParser{}.Encode(trafficEvent())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, c := range codecs {
+		parser := protocol.JSONParser{Codec: c.codec}
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := parser.Decode(data, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/liangsqrt/socketio-client-go/benchmarks/codec
+
+go 1.22.5
+
+require (
+	github.com/goccy/go-json v0.10.3
+	github.com/liangsqrt/socketio-client-go v0.0.0
+)
+
+replace github.com/liangsqrt/socketio-client-go => ../..
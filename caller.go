@@ -1,8 +1,11 @@
 package socketioclient
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"runtime/debug"
 )
 
 type caller struct {
@@ -10,18 +13,40 @@ type caller struct {
 	Args        reflect.Type
 	ArgsPresent bool
 	Out         bool
+	// first parameter is a context.Context
+	Ctx bool
 }
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 var (
 	ErrorCallerNotFunc     = errors.New("f is not function")
-	ErrorCallerNot2Args    = errors.New("f should have 1 or 2 args")
+	ErrorCallerNot2Args    = errors.New("f should have 1 or 2 args, not counting a leading context.Context")
 	ErrorCallerMaxOneValue = errors.New("f should return not more than one value")
+	ErrorCallerErrorArg    = errors.New("error handler argument should be an error or a string")
 )
 
+/*
+*
+Handler panicked, Stack is the stack trace of the panicking goroutine
+*/
+type HandlerPanicError struct {
+	Event string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("handler for %q panicked: %v", e.Event, e.Value)
+}
+
 /*
 *
 Parses function passed by using reflection, and stores its representation
-for further call on message or ack
+for further call on message or ack. Accepted forms are
+
+	func(c *Channel[, args T]) [R]
+	func(ctx context.Context, c *Channel[, args T]) [R]
 */
 func newCaller(f interface{}) (*caller, error) {
 	fVal := reflect.ValueOf(f)
@@ -38,11 +63,16 @@ func newCaller(f interface{}) (*caller, error) {
 		Func: fVal,
 		Out:  fType.NumOut() == 1,
 	}
-	if fType.NumIn() == 1 {
+	numIn := fType.NumIn()
+	if numIn > 0 && fType.In(0) == contextType {
+		curCaller.Ctx = true
+		numIn--
+	}
+	if numIn == 1 {
 		curCaller.Args = nil
 		curCaller.ArgsPresent = false
-	} else if fType.NumIn() == 2 {
-		curCaller.Args = fType.In(1)
+	} else if numIn == 2 {
+		curCaller.Args = fType.In(fType.NumIn() - 1)
 		curCaller.ArgsPresent = true
 	} else {
 		return nil, ErrorCallerNot2Args
@@ -51,6 +81,14 @@ func newCaller(f interface{}) (*caller, error) {
 	return curCaller, nil
 }
 
+/*
+*
+checks the function argument can receive an error, as error or as its message
+*/
+func (c *caller) acceptsError() bool {
+	return c.Args.Kind() == reflect.String || reflect.TypeOf((*error)(nil)).Elem().AssignableTo(c.Args)
+}
+
 /*
 *
 returns function parameter as it is present in it using reflection
@@ -63,7 +101,7 @@ func (c *caller) getArgs() interface{} {
 *
 calls function with given arguments from its representation using reflection
 */
-func (c *caller) callFunc(h *Channel, args interface{}) []reflect.Value {
+func (c *caller) callFunc(ctx context.Context, h *Channel, args interface{}) []reflect.Value {
 	//nil is untyped, so use the default empty value of correct type
 	if args == nil {
 		args = c.getArgs()
@@ -72,6 +110,65 @@ func (c *caller) callFunc(h *Channel, args interface{}) []reflect.Value {
 	if !c.ArgsPresent {
 		a = a[0:1]
 	}
+	if c.Ctx {
+		a = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, a...)
+	}
 
 	return c.Func.Call(a)
 }
+
+/*
+*
+returns the error a function call returned, if any
+*/
+func callError(out []reflect.Value) error {
+	if len(out) == 0 {
+		return nil
+	}
+	err, _ := out[len(out)-1].Interface().(error)
+	return err
+}
+
+/*
+*
+calls function like callFunc, a panic is recovered and returned as
+HandlerPanicError, otherwise the error the function returned is returned
+*/
+func (c *caller) safeCallFunc(ctx context.Context, h *Channel, args interface{}) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			info, _ := EventFromContext(ctx)
+			err = &HandlerPanicError{Event: info.Event, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	out = c.callFunc(ctx, h, args)
+	return out, callError(out)
+}
+
+/*
+*
+runs user code other than handlers, middlewares and catch-all listeners.
+A panic is recovered as HandlerPanicError, reported to the handler error
+callback with ctx and returned
+*/
+func (h *Channel) safeRun(ctx context.Context, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			info, _ := EventFromContext(ctx)
+			err = &HandlerPanicError{Event: info.Event, Value: r, Stack: debug.Stack()}
+			h.handlerError(ctx, err)
+		}
+	}()
+	return f()
+}
+
+/*
+*
+returns the value a function call returned for an ack, if it returned one that is not an error
+*/
+func callResult(out []reflect.Value) (interface{}, bool) {
+	if len(out) != 1 || out[0].Type().Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return nil, false
+	}
+	return out[0].Interface(), true
+}
@@ -1,20 +1,29 @@
 package socketioclient
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/liangsqrt/socketio-client-go/protocol"
 	"github.com/liangsqrt/socketio-client-go/transport"
 )
 
 const (
 	webSocketProtocol       = "ws://"
 	webSocketSecureProtocol = "wss://"
-	socketioUrl             = "/socket.io/?EIO=4&transport=websocket"
+	defaultPath             = "/socket.io/"
+
+	// Engine.IO v4, Socket.IO v3 and v4 servers
+	EngineIOv4 = 4
+	// Engine.IO v3, Socket.IO v2 servers
+	EngineIOv3 = 3
 )
 
 /*
@@ -31,6 +40,8 @@ type Client struct {
 	ReconnectDelay  time.Duration
 	ReconnectMax    int
 	Tr              transport.Transport
+
+	reconnectOnce sync.Once
 }
 
 type ConConf struct {
@@ -41,6 +52,49 @@ type ConConf struct {
 	AutoReconnect  bool
 	ReconnectDelay time.Duration
 	ReconnectMax   int
+	// server path, "/socket.io/" by default
+	Path string
+	// scheme, host and path prefix of the server, e.g. "https://example.com/api",
+	// replaces Host, Port and Secure when set
+	BaseURL string
+	// EngineIOv4 by default, EngineIOv3 for Socket.IO v2 servers
+	ProtocolVersion int
+	// payload of the namespace CONNECT packet
+	Auth interface{}
+	// optional sink for connection and message flow metrics
+	Metrics Metrics
+	// optional tracer started around emits and handled events
+	Tracer Tracer
+	// how incoming packets are handed to handlers, DispatchSequential by default
+	DispatchMode DispatchMode
+	// workers for DispatchPerEvent and DispatchPool, number of CPUs by default
+	DispatchWorkers int
+	// capacity of each dispatch queue
+	DispatchQueueSize int
+	DispatchQueueFull QueueFullPolicy
+	// called with errors returned by handlers and recovered handler panics, logged if nil
+	OnHandlerError func(info EventInfo, err error)
+	// socket.io packet encoding, e.g. protocol.MsgpackParser{}, protocol.JSONParser if nil
+	Parser protocol.Parser
+	// queued packets written together with one flush to the network,
+	// defaultWriteBatch if 0, 1 disables batching
+	WriteBatch int
+	// capacity of the outbound queue, queueBufferSize if 0
+	QueueSize int
+	// queue depth at which OnQueueHighWater is called, half of QueueSize if 0
+	QueueHighWater int
+	// called with true when the queue reaches QueueHighWater and with false
+	// once it drained to half of it. Runs on the emitting goroutine or the
+	// outbound loop, must not block
+	OnQueueHighWater func(depth int, high bool)
+	// queue depth above which Volatile emits are dropped, QueueHighWater if 0
+	VolatileThreshold int
+	// token buckets in front of emits, for the whole client and per event
+	RateLimit       RateLimit
+	EventRateLimits map[string]RateLimit
+	RateLimitMode   RateLimitMode
+	// events sent through the high priority lane, ahead of other emits
+	HighPriorityEvents []string
 }
 
 /*
@@ -48,13 +102,6 @@ type ConConf struct {
 Generate websocket URL from ConConf
 */
 func (s *ConConf) GenerateWebSocketUrl(sid string) string {
-	var protocol string
-	if s.Secure {
-		protocol = webSocketSecureProtocol
-	} else {
-		protocol = webSocketProtocol
-	}
-
 	queryParams := url.Values{}
 	for key, value := range s.Query {
 		queryParams.Add(key, value)
@@ -63,7 +110,7 @@ func (s *ConConf) GenerateWebSocketUrl(sid string) string {
 	//queryParams.Add("EIO", "4")
 	//queryParams.Add("transport", "websocket")
 
-	return protocol + net.JoinHostPort(s.Host, strconv.Itoa(s.Port)) + socketioUrl + "&" + queryParams.Encode()
+	return s.endpoint(true) + "?EIO=" + s.eio() + "&transport=websocket" + "&" + queryParams.Encode()
 }
 
 /*
@@ -71,20 +118,75 @@ func (s *ConConf) GenerateWebSocketUrl(sid string) string {
 Generate handshake URL from ConConf
 */
 func (s *ConConf) GenerateHandshakeUrl() string {
-	var protocol string
-	if s.Secure {
-		protocol = "https://"
-	} else {
-		protocol = "http://"
-	}
-
 	queryParams := url.Values{}
 	for key, value := range s.Query {
 		queryParams.Add(key, value)
 	}
 	timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 	queryParams.Add("t", timestamp)
-	return protocol + net.JoinHostPort(s.Host, strconv.Itoa(s.Port)) + "/socket.io/?EIO=4&transport=polling" + "&" + queryParams.Encode()
+	return s.endpoint(false) + "?EIO=" + s.eio() + "&transport=polling" + "&" + queryParams.Encode()
+}
+
+/*
+*
+Scheme, host, base URL path prefix and server path, the websocket or http
+scheme is chosen by websocket. An invalid BaseURL is ignored here, Connect
+fails with its error before
+*/
+func (s *ConConf) endpoint(websocket bool) string {
+	secure := s.Secure
+	host := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	prefix := ""
+	if base, err := s.baseURL(); err == nil && base != nil {
+		secure = base.Scheme == "https" || base.Scheme == "wss"
+		host = base.Host
+		prefix = strings.TrimRight(base.Path, "/")
+	}
+
+	path := s.Path
+	if path == "" {
+		path = defaultPath
+	}
+	path = strings.Trim(path, "/") + "/"
+	if path != "/" {
+		path = "/" + path
+	}
+
+	var protocol string
+	switch {
+	case websocket && secure:
+		protocol = webSocketSecureProtocol
+	case websocket:
+		protocol = webSocketProtocol
+	case secure:
+		protocol = "https://"
+	default:
+		protocol = "http://"
+	}
+	return protocol + host + prefix + path
+}
+
+/*
+*
+Parsed BaseURL, nil if it is not set
+*/
+func (s *ConConf) baseURL() (*url.URL, error) {
+	if s.BaseURL == "" {
+		return nil, nil
+	}
+	base, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	switch base.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return nil, ErrorUnsupportedScheme
+	}
+	if base.Host == "" {
+		return nil, ErrorMissingHost
+	}
+	return base, nil
 }
 
 /*
@@ -92,14 +194,18 @@ func (s *ConConf) GenerateHandshakeUrl() string {
 Get ws/wss url by host and port
 */
 func GetUrl(socketUrl ConConf) string {
+	return socketUrl.endpoint(true) + "?EIO=" + socketUrl.eio() + "&transport=websocket"
+}
 
-	var prefix string
-	if socketUrl.Secure {
-		prefix = webSocketSecureProtocol
-	} else {
-		prefix = webSocketProtocol
+/*
+*
+Engine.IO version query value, 4 unless EngineIOv3 is selected
+*/
+func (s *ConConf) eio() string {
+	if s.ProtocolVersion == EngineIOv3 {
+		return "3"
 	}
-	return prefix + net.JoinHostPort(socketUrl.Host, strconv.Itoa(socketUrl.Port)) + socketioUrl
+	return "4"
 }
 
 func (c *Client) initNamespace(sid string) error {
@@ -111,7 +217,7 @@ func (c *Client) initNamespace(sid string) error {
 func (c *Client) handshake() error {
 	url := c.Conf.GenerateHandshakeUrl()
 	// frist req：get the sid
-	sid, err := c.Tr.Handshake(url, c.Namespace.Namespace)
+	sid, err := c.handshakeContext(context.Background(), url)
 	if err != nil {
 		return err
 	}
@@ -124,11 +230,25 @@ func (c *Client) handshake() error {
 // ws://localhost:8080/socket.io/?EIO=3&transport=websocket&sid=xxxx
 // /*
 func Dial(conConf ConConf, tr transport.Transport, ns *Namespace) (*Client, error) {
+	return DialContext(context.Background(), conConf, tr, ns)
+}
+
+/*
+*
+Dial, giving up when ctx is done before the client is connected. ctx only
+bounds connecting, it does not limit the lifetime of the connection
+*/
+func DialContext(ctx context.Context, conConf ConConf, tr transport.Transport, ns *Namespace) (*Client, error) {
 	c := &Client{Conf: conConf, Tr: tr, Namespace: ns}
 	c.Namespace = ns
+	c.configure(conConf)
 	c.initChannel()
 	c.initMethods()
-	err := c.Connect()
+	if conConf.AutoReconnect {
+		c.ReconnectDelay = conConf.ReconnectDelay
+		c.ReconnectMax = conConf.ReconnectMax
+	}
+	err := c.ConnectContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +258,7 @@ func Dial(conConf ConConf, tr transport.Transport, ns *Namespace) (*Client, erro
 
 func (c *Client) HandShake(ns *Namespace) error {
 	c.Namespace = ns
+	c.configure(c.Conf)
 	c.initChannel()
 	c.initMethods()
 	if err := c.handshake(); err != nil {
@@ -148,37 +269,164 @@ func (c *Client) HandShake(ns *Namespace) error {
 }
 
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+/*
+*
+Connect, the handshake requests, websocket dial, upgrade exchange and namespace
+CONNECT are aborted when ctx is done
+*/
+func (c *Client) ConnectContext(ctx context.Context) error {
+	if _, err := c.Conf.baseURL(); err != nil {
+		return err
+	}
 	handShakeUrl := c.Conf.GenerateHandshakeUrl()
 	var err error
-	if sid, err := c.Tr.Handshake(handShakeUrl, c.Namespace.Namespace); err != nil {
+	if sid, err := c.handshakeContext(ctx, handShakeUrl); err != nil {
 		return err
 	} else {
 		c.initNamespace(sid)
 	}
 	wsUrl := c.Conf.GenerateWebSocketUrl(c.Namespace.Sid)
-	c.conn, err = c.Tr.Connect(wsUrl)
+	conn, err := c.connectContext(ctx, wsUrl)
 	if err != nil {
 		return err
 	}
-	err = handleUpgrade(&c.Channel, &c.methods)
+	err = handleUpgrade(ctx, &c.Channel, conn)
 	if err != nil {
 		return err
 	}
 
+	c.setConnection(conn)
+	c.startSession()
 	go inLoop(&c.Channel, &c.methods)
 	go outLoop(&c.Channel, &c.methods)
 	go pinger(&c.Channel)
-	go func() {
-		for range c.Tr.(*transport.WebsocketTransport).ReConnectChan {
-			if conn, err := c.Reconnect(); err != nil {
-				log.Println("reconnect failed", err)
-			} else {
-				log.Println("reconnect success")
-				c.conn = conn
+	c.reconnectOnce.Do(func() { go c.reconnectLoop() })
+	return nil
+}
+
+/*
+*
+Connection settings of the client passed to the transport, which may be
+shared with other clients
+*/
+func (c *Client) connParams() transport.ConnParams {
+	params := transport.ConnParams{Auth: c.Conf.Auth, Parser: c.Conf.Parser, OnOpen: c.SetHeader}
+	if c.Conf.Metrics != nil {
+		params.Metrics = c.Conf.Metrics
+	}
+	return params
+}
+
+/*
+*
+Handshake of the client namespace, aborted when ctx is done if the transport
+supports it. Transports without ContextTransport use their own auth and parser
+*/
+func (c *Client) handshakeContext(ctx context.Context, url string) (string, error) {
+	if ct, ok := c.Tr.(transport.ContextTransport); ok {
+		return ct.HandshakeContext(ctx, url, c.Namespace.Namespace, c.connParams())
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.Tr.Handshake(url, c.Namespace.Namespace)
+}
+
+/*
+*
+Dial, aborted when ctx is done if the transport supports it
+*/
+func (c *Client) connectContext(ctx context.Context, url string) (transport.Connection, error) {
+	if ct, ok := c.Tr.(transport.ContextTransport); ok {
+		return ct.ConnectContext(ctx, url, c.connParams())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Tr.Connect(url)
+}
+
+/*
+*
+Create the context and dispatcher of a new connection, ending what is left
+of the previous one
+*/
+func (c *Client) startSession() {
+	c.newSession()
+	c.setAliveValue(true)
+}
+
+func (c *Client) newSession() {
+	c.endSession()
+	d := newDispatcher(&c.Conf, c.stats())
+	ctx, cancel := context.WithCancel(context.Background())
+	c.sessionLock.Lock()
+	c.dispatcher = d
+	c.ctx, c.cancel = ctx, cancel
+	c.sessionLock.Unlock()
+}
+
+/*
+*
+Reconnect whenever the connection signals it is lost, until the client is
+closed. When the reconnect gives up the client is closed
+*/
+func (c *Client) reconnectLoop() {
+	for {
+		conn, changed := c.watchConnection()
+		// nil for connections that are never replaced, only changed fires then
+		var lost <-chan struct{}
+		if rc, ok := conn.(transport.ReconnectConnection); ok {
+			lost = rc.ReConnectChan()
+		}
+		select {
+		case <-changed:
+			if !c.IsAlive() {
+				return
 			}
+			continue
+		case <-lost:
 		}
-	}()
-	return nil
+
+		// the connection is lost, handlers waiting on its context stop and
+		// its reader is unblocked
+		c.endSession()
+		conn.Close()
+		next, err := c.Reconnect()
+		if err != nil {
+			log.Println("reconnect failed", err)
+			closeChannel(&c.Channel, &c.methods, err)
+			return
+		}
+		if !c.replaceConnection(next) {
+			// closed while reconnecting
+			next.Close()
+			return
+		}
+		log.Println("reconnect success")
+		go inLoop(&c.Channel, &c.methods)
+	}
+}
+
+/*
+*
+Switch to the reconnected conn with a new session, unless the client was
+closed meanwhile
+*/
+func (c *Client) replaceConnection(conn transport.Connection) bool {
+	// closeChannel must either see conn or run before it
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+	if !c.alive {
+		return false
+	}
+	// the session is ready before the connection watchers wake
+	c.newSession()
+	c.setConnection(conn)
+	return true
 }
 
 /*
@@ -192,33 +440,127 @@ func (c *Client) Close() {
 	}
 }
 
+/*
+*
+Emit without waiting, fails with ErrorSocketOverflood when the outbound
+queue is full. Use EmitContext to wait for room
+*/
 func (c *Client) Emit(method string, args interface{}) error {
-	return c.Channel.emit(method, c.Namespace.Namespace, args)
+	return c.emit(context.Background(), method, args, 0, queueTry, false)
+}
+
+/*
+*
+Emit with ctx as parent of the emit span. When the outbound queue is full
+it waits for room until ctx is done or the connection is closed
+*/
+func (c *Client) EmitContext(ctx context.Context, method string, args interface{}) error {
+	return c.emit(ctx, method, args, 0, queueBlock, false)
 }
 
-func (c *Client) On(method string, callback interface{}) {
-	c.methods.On(method, callback)
+/*
+*
+Emit without waiting, same as Emit
+*/
+func (c *Client) TryEmit(method string, args interface{}) error {
+	return c.emit(context.Background(), method, args, 0, queueTry, false)
+}
+
+func (c *Client) emit(ctx context.Context, method string, args interface{}, ackID int, mode queueMode, high bool) error {
+	tokens, err := c.limiter.wait(ctx, c.Context().Done(), method, c.stats(), mode)
+	if err != nil {
+		if mode == queueVolatile && errors.Is(err, ErrorRateLimitDropped) {
+			return nil
+		}
+		return err
+	}
+	err = c.Channel.emit(ctx, method, c.Namespace.Namespace, args, ackID, mode, high)
+	if err == nil {
+		return nil
+	}
+	// dropped by a middleware, as volatile or on a full queue, the rate
+	// limit applies to packets queued
+	tokens.refund()
+	if errors.Is(err, errVolatileDropped) {
+		c.stats().AddCounter(MetricVolatileDropped, 1, eventLabels(method))
+		return nil
+	}
+	var dropped *MiddlewareError
+	if errors.As(err, &dropped) {
+		p := &Packet{SocketType: protocol.SocketMessageTypeEvent}
+		p.SocketEvent.EventName = method
+		p.SocketEvent.NS = c.Namespace.Namespace
+		c.methods.raiseError(withEvent(c.Context(), p), &c.Channel, err)
+	}
+	return err
+}
+
+/*
+*
+Add handler for given event, every handler added for an event is called
+*/
+func (c *Client) On(method string, callback interface{}) (Subscription, error) {
+	return c.methods.On(method, callback)
+}
+
+/*
+*
+Add handler for given event, removed after its first call
+*/
+func (c *Client) Once(method string, callback interface{}) (Subscription, error) {
+	return c.methods.Once(method, callback)
+}
+
+/*
+*
+Remove handler added by On or Once
+*/
+func (c *Client) Off(method string, sub Subscription) bool {
+	return c.methods.Off(method, sub)
+}
+
+func (c *Client) RemoveAllListeners(method string) {
+	c.methods.RemoveAllListeners(method)
 }
 
+func (c *Client) ListenerCount(method string) int {
+	return c.methods.ListenerCount(method)
+}
+
+/*
+*
+Open a new upgraded connection, retrying up to ReconnectMax times. Gives up
+with ErrorSocketClosed once the client is closed
+*/
 func (c *Client) Reconnect() (conn transport.Connection, err error) {
 	for c.ReconnectCount < c.ReconnectMax {
 		c.ReconnectCount++
+		c.stats().AddCounter(MetricReconnectAttempts, 1, nil)
 		// 指数退避
 		delay := c.ReconnectDelay * time.Duration(c.ReconnectCount)
 		if time.Since(c.LastConnectTime) < delay {
 			time.Sleep(delay - time.Since(c.LastConnectTime))
 		}
-		if sid, err := c.Tr.Handshake(c.Conf.GenerateHandshakeUrl(), c.Namespace.Namespace); err != nil {
+		if !c.IsAlive() {
+			return nil, ErrorSocketClosed
+		}
+		sid, err := c.handshakeContext(context.Background(), c.Conf.GenerateHandshakeUrl())
+		if err != nil {
 			log.Println("reconnect failed", err)
-		} else {
-			c.initNamespace(sid)
+			continue
 		}
-		if conn, err := c.Tr.Connect(c.Conf.GenerateWebSocketUrl(c.Namespace.Sid)); err != nil {
+		c.initNamespace(sid)
+		if conn, err := c.connectContext(context.Background(), c.Conf.GenerateWebSocketUrl(c.Namespace.Sid)); err != nil {
+			log.Println("reconnect failed", err)
+		} else if err := handleUpgrade(context.Background(), &c.Channel, conn); err != nil {
+			// handleUpgrade closed conn
 			log.Println("reconnect failed", err)
 		} else {
 			c.ReconnectCount = 0
+			c.stats().AddCounter(MetricReconnects, 1, map[string]string{"result": "success"})
 			return conn, nil
 		}
 	}
+	c.stats().AddCounter(MetricReconnects, 1, map[string]string{"result": "failure"})
 	return nil, errors.New("reconnect failed")
 }
@@ -0,0 +1,74 @@
+package socketioclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		conf ConConf
+		want string
+	}{
+		{"default path", ConConf{Host: "example.com", Port: 80}, "ws://example.com:80/socket.io/"},
+		{"secure", ConConf{Host: "example.com", Port: 443, Secure: true}, "wss://example.com:443/socket.io/"},
+		{"custom path", ConConf{Host: "example.com", Port: 80, Path: "realtime"}, "ws://example.com:80/realtime/"},
+		{"nested path", ConConf{Host: "example.com", Port: 80, Path: "/a/b/"}, "ws://example.com:80/a/b/"},
+		{"root path", ConConf{Host: "example.com", Port: 80, Path: "/"}, "ws://example.com:80/"},
+		{"ipv6", ConConf{Host: "::1", Port: 3000}, "ws://[::1]:3000/socket.io/"},
+		{"base url", ConConf{Host: "ignored", BaseURL: "https://example.com/api"}, "wss://example.com/api/socket.io/"},
+		{"base url slash", ConConf{BaseURL: "http://example.com:8080/api/", Path: "/"}, "ws://example.com:8080/api/"},
+		{"base url root", ConConf{BaseURL: "https://example.com/", Path: "/io"}, "wss://example.com/io/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.endpoint(true); got != tt.want {
+				t.Fatalf("endpoint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedUrls(t *testing.T) {
+	conf := ConConf{Host: "example.com", Port: 80, Query: map[string]string{"token": "a b"}}
+	handshake := conf.GenerateHandshakeUrl()
+	if !strings.HasPrefix(handshake, "http://example.com:80/socket.io/?EIO=4&transport=polling&") || !strings.Contains(handshake, "token=a+b") {
+		t.Fatalf("handshake url = %s", handshake)
+	}
+	ws := conf.GenerateWebSocketUrl("abc")
+	if !strings.HasPrefix(ws, "ws://example.com:80/socket.io/?EIO=4&transport=websocket&") || !strings.Contains(ws, "sid=abc") {
+		t.Fatalf("websocket url = %s", ws)
+	}
+	conf.ProtocolVersion = EngineIOv3
+	if !strings.Contains(conf.GenerateHandshakeUrl(), "?EIO=3&transport=polling") {
+		t.Fatalf("v3 handshake url = %s", conf.GenerateHandshakeUrl())
+	}
+	if ws := conf.GenerateWebSocketUrl("abc"); !strings.Contains(ws, "?EIO=3&transport=websocket") || !strings.Contains(ws, "sid=abc") {
+		t.Fatalf("v3 websocket url = %s", ws)
+	}
+}
+
+func TestInvalidBaseURL(t *testing.T) {
+	for _, base := range []string{"://bad", "ftp://example.com", "http:///api"} {
+		c := newTestClient(ConConf{BaseURL: base})
+		c.Tr = &fakeTransport{conn: newFakeConn()}
+		if err := c.Connect(); err == nil {
+			t.Fatalf("Connect with BaseURL %q succeeded", base)
+		}
+	}
+}
+
+func TestCustomPath(t *testing.T) {
+	server := newTestServer(t)
+	c, err := New(server.URL+"/chat", WithPath("/realtime"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, r := range server.allRequests() {
+		if r.URL.Path != "/realtime/" {
+			t.Fatalf("request to %s", r.URL.Path)
+		}
+	}
+}
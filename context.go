@@ -0,0 +1,51 @@
+package socketioclient
+
+import (
+	"context"
+)
+
+/*
+*
+Describes the packet a handler was called for
+*/
+type EventInfo struct {
+	Event     string
+	Namespace string
+	// packet id, HasID is false when the server did not ask for an ack
+	ID    int
+	HasID bool
+}
+
+type eventInfoKey struct{}
+
+/*
+*
+Get the event a handler context was created for
+*/
+func EventFromContext(ctx context.Context) (EventInfo, bool) {
+	info, ok := ctx.Value(eventInfoKey{}).(EventInfo)
+	return info, ok
+}
+
+func withEvent(ctx context.Context, p *Packet) context.Context {
+	return context.WithValue(ctx, eventInfoKey{}, EventInfo{
+		Event:     p.SocketEvent.EventName,
+		Namespace: p.SocketEvent.NS,
+		ID:        p.SocketEvent.ID,
+		HasID:     p.SocketEvent.HasID,
+	})
+}
+
+/*
+*
+Context of the current connection, cancelled when the socket disconnects or
+the client is closed. A reconnect starts a new context
+*/
+func (c *Channel) Context() context.Context {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
@@ -0,0 +1,105 @@
+package socketioclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+	"github.com/liangsqrt/socketio-client-go/transport"
+)
+
+func TestContextHandler(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.startSession()
+	defer c.endSession()
+
+	var info EventInfo
+	var handlerCtx context.Context
+	c.On("say", func(ctx context.Context, ch *Channel, msg *protocol.Message) {
+		info, _ = EventFromContext(ctx)
+		handlerCtx = ctx
+	})
+	receive(c, `42/chat,7["say","hi"]`)
+
+	want := EventInfo{Event: "say", Namespace: "chat", ID: 7, HasID: true}
+	if info != want {
+		t.Fatalf("event info = %+v, want %+v", info, want)
+	}
+	if handlerCtx.Err() != nil {
+		t.Fatal("handler context done while connected")
+	}
+}
+
+func TestContextCancelledOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+	}{
+		{"engine close", "1"},
+		{"namespace disconnect", "41"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(ConConf{})
+			c.startSession()
+			ctx := c.Context()
+			disconnected := false
+			c.On(OnDisconnection, func(ch *Channel) { disconnected = true })
+
+			engineType, _ := protocol.GetEngineMessageType(tt.packet)
+			c.methods.processIncomingMessage(&c.Channel, engineType, tt.packet)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Fatal("connection context not cancelled")
+			}
+			if !disconnected {
+				t.Fatal("OnDisconnection not called")
+			}
+			if c.IsAlive() {
+				t.Fatal("channel still alive")
+			}
+		})
+	}
+}
+
+func TestContextRenewedOnReconnect(t *testing.T) {
+	first := newLosableConn("3probe", "6")
+	second := newLosableConn("3probe", "6")
+	tr := &reconnectTransport{conns: []transport.Connection{first, second}}
+	c, err := Dial(ConConf{Host: "localhost", Port: 80, AutoReconnect: true, ReconnectMax: 1}, tr, &Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	lostCtx := c.Context()
+
+	_, changed := c.watchConnection()
+	close(first.lost)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not replaced")
+	}
+
+	if lostCtx.Err() == nil {
+		t.Fatal("context of the lost connection not cancelled")
+	}
+	if c.Context() == lostCtx || c.Context().Err() != nil {
+		t.Fatal("reconnect did not start a new context")
+	}
+	if first.closeCount() != 1 {
+		t.Fatalf("lost connection closed %d times", first.closeCount())
+	}
+	second.lock.Lock()
+	written := append([]string(nil), second.written...)
+	second.lock.Unlock()
+	if len(written) != 2 || written[0] != "2probe" || written[1] != "5" {
+		t.Fatalf("new connection not upgraded, written = %v", written)
+	}
+	if !c.IsAlive() || c.connection() != second {
+		t.Fatal("client not running on the new connection")
+	}
+}
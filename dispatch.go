@@ -0,0 +1,138 @@
+package socketioclient
+
+import (
+	"hash/fnv"
+	"log"
+	"runtime"
+	"sync"
+)
+
+const (
+	defaultDispatchQueueSize = 1024
+)
+
+/*
+*
+How incoming packets are handed to handlers
+*/
+type DispatchMode int
+
+const (
+	// one worker, handlers see packets in wire order
+	DispatchSequential DispatchMode = iota
+	// packets of the same event keep wire order, different events run on different workers
+	DispatchPerEvent
+	// workers share one queue, no ordering
+	DispatchPool
+)
+
+/*
+*
+What to do with an incoming packet when the dispatch queue is full
+*/
+type QueueFullPolicy int
+
+const (
+	// stop reading from the socket until there is room
+	QueueFullBlock QueueFullPolicy = iota
+	// drop the packet
+	QueueFullDrop
+)
+
+/*
+*
+Dispatcher runs packet processing on a fixed number of workers
+*/
+type dispatcher struct {
+	queues  []chan func()
+	policy  QueueFullPolicy
+	metrics Metrics
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDispatcher(conf *ConConf, metrics Metrics) *dispatcher {
+	workers := conf.DispatchWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queueSize := conf.DispatchQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultDispatchQueueSize
+	}
+
+	d := &dispatcher{
+		policy:  conf.DispatchQueueFull,
+		metrics: metrics,
+		done:    make(chan struct{}),
+	}
+	switch conf.DispatchMode {
+	case DispatchPerEvent:
+		d.queues = make([]chan func(), workers)
+		for i := range d.queues {
+			d.queues[i] = make(chan func(), queueSize)
+			go d.work(d.queues[i])
+		}
+	case DispatchPool:
+		d.queues = []chan func(){make(chan func(), queueSize)}
+		for i := 0; i < workers; i++ {
+			go d.work(d.queues[0])
+		}
+	default:
+		d.queues = []chan func(){make(chan func(), queueSize)}
+		go d.work(d.queues[0])
+	}
+	return d
+}
+
+func (d *dispatcher) work(queue chan func()) {
+	for {
+		select {
+		case task := <-queue:
+			task()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+/*
+*
+Queue task, tasks with the same key keep their order in DispatchPerEvent mode
+*/
+func (d *dispatcher) dispatch(key string, task func()) {
+	queue := d.queueFor(key)
+
+	if d.policy == QueueFullDrop {
+		select {
+		case queue <- task:
+		case <-d.done:
+		default:
+			log.Println("socket.io dispatch queue full, dropping packet", key)
+			d.metrics.AddCounter(MetricDispatchDropped, 1, eventLabels(key))
+		}
+		return
+	}
+	select {
+	case queue <- task:
+	case <-d.done:
+	}
+}
+
+func (d *dispatcher) queueFor(key string) chan func() {
+	if len(d.queues) == 1 {
+		return d.queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return d.queues[h.Sum32()%uint32(len(d.queues))]
+}
+
+/*
+*
+Stop workers, queued tasks are discarded
+*/
+func (d *dispatcher) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+}
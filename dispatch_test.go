@@ -0,0 +1,236 @@
+package socketioclient
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+type counterMetrics struct {
+	noopMetrics
+	lock     sync.Mutex
+	counters map[string]float64
+}
+
+func (m *counterMetrics) AddCounter(name string, delta float64, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters == nil {
+		m.counters = make(map[string]float64)
+	}
+	m.counters[name] += delta
+}
+
+func (m *counterMetrics) counter(name string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counters[name]
+}
+
+func TestDispatchSequentialOrder(t *testing.T) {
+	d := newDispatcher(&ConConf{}, noopMetrics{})
+	defer d.stop()
+	var got []int
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		i := i
+		wg.Add(1)
+		d.dispatch("event", func() {
+			got = append(got, i)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("task %d ran at position %d", v, i)
+		}
+	}
+}
+
+func TestDispatchPerEvent(t *testing.T) {
+	d := newDispatcher(&ConConf{DispatchMode: DispatchPerEvent, DispatchWorkers: 8}, noopMetrics{})
+	defer d.stop()
+
+	// a blocked event does not hold back the others
+	release := make(chan struct{})
+	d.dispatch("slow", func() { <-release })
+	done := make(chan struct{})
+	key := "fast"
+	for i := 0; d.queueFor(key) == d.queueFor("slow"); i++ {
+		key = string(rune('a' + i))
+	}
+	d.dispatch(key, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event waited behind another event")
+	}
+	close(release)
+
+	// packets of one event keep their order
+	var got []int
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		i := i
+		wg.Add(1)
+		d.dispatch("ordered", func() {
+			got = append(got, i)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("task %d ran at position %d", v, i)
+		}
+	}
+}
+
+func TestDispatchPool(t *testing.T) {
+	d := newDispatcher(&ConConf{DispatchMode: DispatchPool, DispatchWorkers: 4}, noopMetrics{})
+	defer d.stop()
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		d.dispatch("event", func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+	if peak.Load() < 2 {
+		t.Fatalf("pool ran at most %d tasks at once", peak.Load())
+	}
+}
+
+func TestDispatchQueueFullDrop(t *testing.T) {
+	metrics := &counterMetrics{}
+	d := newDispatcher(&ConConf{DispatchQueueSize: 1, DispatchQueueFull: QueueFullDrop}, metrics)
+	defer d.stop()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.dispatch("event", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	d.dispatch("event", func() {})
+	d.dispatch("event", func() {})
+	close(release)
+	if n := metrics.counter(MetricDispatchDropped); n != 1 {
+		t.Fatalf("dropped = %v, want 1", n)
+	}
+}
+
+func TestStartSessionStopsDispatcher(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.startSession()
+	old := c.dispatcher
+	c.startSession()
+	select {
+	case <-old.done:
+	default:
+		t.Fatal("previous dispatcher still running")
+	}
+	if c.dispatcher == old {
+		t.Fatal("dispatcher not replaced")
+	}
+	c.endSession()
+	ran := false
+	c.dispatch("event", func() { ran = true })
+	if ran {
+		t.Fatal("task dispatched after stop")
+	}
+}
+
+func TestOpenMessage(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.On(OnConnection, func(ch *Channel) {
+		c.TryEmit("hello", 1)
+	})
+	start := time.Now()
+	c.methods.processIncomingMessage(&c.Channel, 0, `0{"sid":"abc","pingInterval":25000}`)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("open message took %v", elapsed)
+	}
+	got := queued(c)
+	if len(got) != 2 || got[0] != "40" || got[1] != `42/,0["hello",1]` {
+		t.Fatalf("queued = %v", got)
+	}
+	if c.Id() != "abc" {
+		t.Fatalf("sid = %q", c.Id())
+	}
+}
+
+/*
+*
+Connection receiving binary frames
+*/
+type binaryConn struct {
+	*fakeConn
+	frames chan []byte
+}
+
+func (b *binaryConn) GetFrame() ([]byte, bool, error) {
+	select {
+	case data := <-b.frames:
+		return data, true, nil
+	case <-b.closed:
+		return nil, false, errors.New("closed")
+	}
+}
+
+func (b *binaryConn) WriteFrame(data []byte, binary bool) error {
+	return b.WriteMessage(string(data))
+}
+
+func TestBinaryFramesDispatchedPerEvent(t *testing.T) {
+	parser := protocol.MsgpackParser{}
+	c := newTestClient(ConConf{Parser: parser, DispatchMode: DispatchPerEvent, DispatchWorkers: 8})
+	c.startSession()
+	defer c.endSession()
+	conn := &binaryConn{fakeConn: newFakeConn(), frames: make(chan []byte, 10)}
+	c.conn = conn
+	defer conn.Close()
+
+	fast := "fast"
+	for i := 0; c.dispatcher.queueFor(fast) == c.dispatcher.queueFor("slow"); i++ {
+		fast = string(rune('a' + i))
+	}
+	release := make(chan struct{})
+	defer close(release)
+	done := make(chan struct{})
+	c.On("slow", func(ch *Channel) { <-release })
+	c.On(fast, func(ch *Channel) { close(done) })
+
+	for _, event := range []string{"slow", fast} {
+		msg := &protocol.Message{EngineIoType: protocol.EngineMessageTypeMessage, SocketType: protocol.SocketMessageTypeEvent}
+		msg.SocketEvent.EventName = event
+		data, _, err := parser.Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.frames <- data
+	}
+	go inLoop(&c.Channel, &c.methods)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("binary event waited behind another event")
+	}
+}
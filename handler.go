@@ -1,8 +1,12 @@
 package socketioclient
 
 import (
+	"context"
+	"errors"
+	"log"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/liangsqrt/socketio-client-go/protocol"
@@ -43,28 +47,138 @@ func (m *methods) initMethods() {
 
 /*
 *
-Add message processing function, and bind it to given method
+Remove every message handler, running handlers may still read the map
 */
-func (m *methods) On(method string, f interface{}) error {
+func (m *methods) clearMethods() {
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+	m.messageHandlers.Range(func(method, _ interface{}) bool {
+		m.messageHandlers.Delete(method)
+		return true
+	})
+}
+
+/*
+*
+Registered message processing function
+*/
+type listener struct {
+	sub    Subscription
+	caller *caller
+	once   bool
+	fired  atomic.Bool
+}
+
+/*
+*
+Add message processing function, and bind it to given method. Listeners of
+a method are called in the order they were added
+*/
+func (m *methods) On(method string, f interface{}) (Subscription, error) {
+	return m.addListener(method, f, false)
+}
+
+/*
+*
+Add message processing function removed after its first call
+*/
+func (m *methods) Once(method string, f interface{}) (Subscription, error) {
+	return m.addListener(method, f, true)
+}
+
+func (m *methods) addListener(method string, f interface{}, once bool) (Subscription, error) {
 	c, err := newCaller(f)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if method == OnError && c.ArgsPresent && !c.acceptsError() {
+		return 0, ErrorCallerErrorArg
 	}
+	l := &listener{sub: nextSubscription(), caller: c, once: once}
+
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+	list := m.listeners(method)
+	m.messageHandlers.Store(method, append(list[:len(list):len(list)], l))
+	return l.sub, nil
+}
+
+/*
+*
+Remove listener of given method, returns false if it was not found
+*/
+func (m *methods) Off(method string, sub Subscription) bool {
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+	return m.removeListener(method, sub)
+}
+
+/*
+*
+Remove every listener of given method
+*/
+func (m *methods) RemoveAllListeners(method string) {
+	m.messageHandlersLock.Lock()
+	m.messageHandlers.Delete(method)
+	m.messageHandlersLock.Unlock()
+}
+
+/*
+*
+Number of listeners bound to given method
+*/
+func (m *methods) ListenerCount(method string) int {
+	return len(m.listeners(method))
+}
 
-	m.messageHandlers.Store(method, c)
+func (m *methods) listeners(method string) []*listener {
+	if list, ok := m.messageHandlers.Load(method); ok {
+		return list.([]*listener)
+	}
 	return nil
 }
 
 /*
 *
-Find message processing function associated with given method
+must be called with messageHandlersLock held
 */
-func (m *methods) findMethod(method string) (*caller, bool) {
-	if f, ok := m.messageHandlers.Load(method); ok {
-		return f.(*caller), true
+func (m *methods) removeListener(method string, sub Subscription) bool {
+	list := m.listeners(method)
+	for i, l := range list {
+		if l.sub != sub {
+			continue
+		}
+		kept := append(append([]*listener{}, list[:i]...), list[i+1:]...)
+		if len(kept) == 0 {
+			m.messageHandlers.Delete(method)
+		} else {
+			m.messageHandlers.Store(method, kept)
+		}
+		return true
 	}
+	return false
+}
 
-	return nil, false
+/*
+*
+Find message processing functions associated with given method, once
+listeners are removed as they are returned
+*/
+func (m *methods) findMethod(method string) []*caller {
+	list := m.listeners(method)
+	callers := make([]*caller, 0, len(list))
+	for _, l := range list {
+		if l.once {
+			if !l.fired.CompareAndSwap(false, true) {
+				continue
+			}
+			m.messageHandlersLock.Lock()
+			m.removeListener(method, l.sub)
+			m.messageHandlersLock.Unlock()
+		}
+		callers = append(callers, l.caller)
+	}
+	return callers
 }
 
 /*
@@ -80,62 +194,173 @@ func (m *methods) processIncomingMessage(c *Channel, engineIoType protocol.Engin
 		m.processOpenMessage(c, pkg)
 	case protocol.EngineMessageTypePing:
 		m.processPingMessage(c)
+	case protocol.EngineMessageTypePong:
+		observeHeartbeat(c)
 	case protocol.EngineMessageTypeMessage:
-		m.processSocketMessage(c, pkg)
+		m.processSocketMessage(c, []byte(pkg), false)
 	case protocol.EngineMessageTypeClose:
 		m.processDisconnectMessage(c)
 
 	}
 }
 
-func (m *methods) processSocketMessage(c *Channel, pkg string) {
-	socketType, err := protocol.GetSocketMessageType(pkg)
+func (m *methods) processSocketMessage(c *Channel, data []byte, binary bool) {
+	msg, err := c.parser().Decode(data, binary)
 	if err != nil {
 		return
 	}
+	m.processDecodedMessage(c, msg)
+}
+
+/*
+*
+Process socket.io packet decoded by the parser
+*/
+func (m *methods) processDecodedMessage(c *Channel, msg *protocol.Message) {
+	var err error
 	//logger.LogDebugSocketIo("Socket IO type: (" + socketType.String())
-	if socketType == protocol.SocketMessageTypeEvent { //Decode socket.io message type
-		msg, err := protocol.GetSocketIoMessage(pkg)
-		if err != nil {
-			return
-		}
+	if msg.SocketType == protocol.SocketMessageTypeDisconnect {
+		// the server closed the namespace
+		closeChannel(c, m)
+		return
+	}
+	if msg.SocketType == protocol.SocketMessageTypeAck {
+		c.processAck(msg)
+		return
+	}
+	if msg.SocketType == protocol.SocketMessageTypeEvent { //Decode socket.io message type
 		if msg.SocketEvent.EventName == "" {
 			return
 		}
-		f, ok := m.findMethod(msg.SocketEvent.EventName)
-		if !ok {
-			return
+		c.stats().AddCounter(MetricMessagesReceived, 1, eventLabels(msg.SocketEvent.EventName))
+		// the span starts first, so middlewares and catch-all listeners see
+		// the arguments without the trace carrier
+		ctx, end := c.trace().StartEvent(withEvent(c.Context(), msg), msg)
+		var handlerErr error
+		err = c.safeRun(ctx, func() error {
+			return c.middlewares.runIncoming(ctx, msg, func(ctx context.Context, p *Packet) error {
+				c.anyListeners.emitIncoming(ctx, c, p)
+				callers := m.findMethod(p.SocketEvent.EventName)
+				if len(callers) == 0 {
+					return nil
+				}
+				var (
+					errs      []error
+					result    interface{}
+					hasResult bool
+				)
+				for _, f := range callers {
+					out, err := callHandler(ctx, f, c, p)
+					if err != nil {
+						errs = append(errs, err)
+						c.handlerError(ctx, err)
+					} else if !hasResult {
+						result, hasResult = callResult(out)
+					}
+				}
+				handlerErr = errors.Join(errs...)
+				if p.SocketEvent.HasID && (hasResult || handlerErr != nil) {
+					sendAck(c, p, result, handlerErr)
+				}
+				return nil
+			})
+		})
+		end(errors.Join(err, handlerErr))
+		// panics were reported as handler errors already
+		var panicked *HandlerPanicError
+		if err != nil && !errors.As(err, &panicked) {
+			m.raiseError(ctx, c, err)
 		}
+	}
+
+}
+
+/*
+*
+Pass error to the OnError handler, as error or string depending on its
+argument type. ctx tells the handler which event failed
+*/
+func (m *methods) raiseError(ctx context.Context, c *Channel, err error) {
+	callers := m.findMethod(OnError)
+	if len(callers) == 0 {
+		log.Println("socket.io error:", err)
+		return
+	}
+	for _, f := range callers {
+		var callErr error
 		if !f.ArgsPresent {
-			f.callFunc(c, &struct{}{})
-			return
-		}
-		data := f.getArgs()
-		dataType := reflect.TypeOf(data)
-
-		if dataType != nil && dataType.Kind() == reflect.Ptr && dataType.Elem().Kind() == reflect.Slice && dataType.Elem().Elem() == reflect.TypeOf((*interface{})(nil)).Elem() {
-			structReceived := []interface {
-			}{
-				c,
-				msg.SocketEvent.EventName,
-				msg.SocketEvent.EventContent,
-			}
-			f.callFunc(c, &structReceived)
+			_, callErr = f.safeCallFunc(ctx, c, &struct{}{})
+		} else if f.Args.Kind() == reflect.String {
+			text := err.Error()
+			_, callErr = f.safeCallFunc(ctx, c, &text)
 		} else {
-			f.callFunc(c, &msg)
+			_, callErr = f.safeCallFunc(ctx, c, &err)
+		}
+		if callErr != nil {
+			c.handlerError(ctx, callErr)
 		}
+	}
+}
 
+/*
+*
+Reply to an event the server requested an ack for, with the handler result
+or {"error": message} when a handler failed. Events whose handlers returned
+nothing are not acknowledged
+*/
+func sendAck(c *Channel, p *Packet, result interface{}, err error) {
+	reply := protocol.Message{
+		EngineIoType: protocol.EngineMessageTypeMessage,
+		SocketType:   protocol.SocketMessageTypeAck,
+	}
+	reply.SocketEvent.NS = p.SocketEvent.NS
+	reply.SocketEvent.ID = p.SocketEvent.ID
+	reply.SocketEvent.HasID = true
+	if err != nil {
+		reply.SocketEvent.EventContent = map[string]string{"error": err.Error()}
+	} else {
+		reply.SocketEvent.EventContent = result
 	}
 
+	if err := c.sendPacket(c.Context(), &reply, queueTry, true); errors.Is(err, ErrorSocketOverflood) {
+		controlDropped(c, "ack", err)
+	} else if err != nil {
+		log.Println("socket.io ack encode failed:", err)
+	}
+}
+
+/*
+*
+Call event handler with the decoded message, returns what the handler
+returned and its error or panic
+*/
+func callHandler(ctx context.Context, f *caller, c *Channel, msg *protocol.Message) ([]reflect.Value, error) {
+	if !f.ArgsPresent {
+		return f.safeCallFunc(ctx, c, &struct{}{})
+	}
+	data := f.getArgs()
+	dataType := reflect.TypeOf(data)
+
+	if dataType != nil && dataType.Kind() == reflect.Ptr && dataType.Elem().Kind() == reflect.Slice && dataType.Elem().Elem() == reflect.TypeOf((*interface{})(nil)).Elem() {
+		structReceived := []interface {
+		}{
+			c,
+			msg.SocketEvent.EventName,
+			msg.SocketEvent.EventContent,
+		}
+		return f.safeCallFunc(ctx, c, &structReceived)
+	}
+	return f.safeCallFunc(ctx, c, &msg)
 }
 
 func (m *methods) processPingMessage(c *Channel) {
+	c.pingAt.Store(time.Now().UnixNano())
 
 	reply := protocol.Message{}
 	reply.EngineIoType = protocol.EngineMessageTypePong
 	reply.SocketType = protocol.SocketMessageTypeNone
 	command, _ := protocol.Encode(&reply)
-	send(command, c)
+	send(textFrame(command), c, "pong")
 
 }
 func (m *methods) processOpenMessage(c *Channel, pkg string) {
@@ -146,17 +371,18 @@ func (m *methods) processOpenMessage(c *Channel, pkg string) {
 	reply.EngineIoType = protocol.EngineMessageTypeMessage
 	reply.SocketType = protocol.SocketMessageTypeConnect
 
-	command, _ := protocol.Encode(&reply)
-	send(command, c)
-	time.Sleep(100 * time.Millisecond) //just to be sure the open message is processed
-	f, _ := m.findMethod(OnConnection)
-	if f == nil {
-		return
+	// queued in the high priority lane, ahead of what the handlers emit
+	if err := c.sendPacket(c.Context(), &reply, queueTry, true); errors.Is(err, ErrorSocketOverflood) {
+		controlDropped(c, "connect", err)
+	}
+	for _, f := range m.findMethod(OnConnection) {
+		if _, err := f.safeCallFunc(c.Context(), c, &struct{}{}); err != nil {
+			c.handlerError(c.Context(), err)
+		}
 	}
-	f.callFunc(c, &struct{}{})
 
 }
 func (m *methods) processDisconnectMessage(c *Channel) {
-	m.onDisconnection(c)
+	closeChannel(c, m)
 
 }
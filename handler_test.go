@@ -0,0 +1,242 @@
+package socketioclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+/*
+*
+Client ready to process packets without a connection, queued frames stay in
+its outbound queue
+*/
+func newTestClient(conf ConConf) *Client {
+	c := &Client{Conf: conf, Namespace: &Namespace{}}
+	c.configure(conf)
+	c.initChannel()
+	c.initMethods()
+	return c
+}
+
+/*
+*
+Frames queued so far, high priority lane first
+*/
+func queued(c *Client) []string {
+	var frames []string
+	for _, out := range []chan frame{c.outHigh, c.out} {
+		for len(out) > 0 {
+			f := <-out
+			frames = append(frames, string(f.data))
+			f.release()
+		}
+	}
+	return frames
+}
+
+func receive(c *Client, pkg string) {
+	c.methods.processSocketMessage(&c.Channel, []byte(pkg), false)
+}
+
+func TestOnErrorArgumentType(t *testing.T) {
+	c := newTestClient(ConConf{})
+	valid := []interface{}{
+		func(c *Channel) {},
+		func(c *Channel, err error) {},
+		func(c *Channel, text string) {},
+		func(c *Channel, v interface{}) {},
+		func(ctx context.Context, c *Channel, err error) {},
+	}
+	for _, f := range valid {
+		if _, err := c.On(OnError, f); err != nil {
+			t.Fatalf("On(OnError, %T) = %v", f, err)
+		}
+	}
+	if _, err := c.On(OnError, func(c *Channel, n int) {}); err != ErrorCallerErrorArg {
+		t.Fatalf("On(OnError, func(*Channel, int)) = %v, want ErrorCallerErrorArg", err)
+	}
+	// other events accept any argument type
+	if _, err := c.On("count", func(c *Channel, n int) {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRaiseErrorContext(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.Namespace.Namespace = "chat"
+	dropped := errors.New("dropped")
+	c.UseIncoming(func(ctx context.Context, p *Packet, next NextFunc) error {
+		return dropped
+	})
+
+	var got EventInfo
+	var gotErr error
+	c.On(OnError, func(ctx context.Context, c *Channel, err error) {
+		got, _ = EventFromContext(ctx)
+		gotErr = err
+	})
+	var text string
+	c.On(OnError, func(c *Channel, s string) {
+		text = s
+	})
+
+	receive(c, `42/chat,["say","hi"]`)
+
+	if got.Event != "say" || got.Namespace != "chat" {
+		t.Fatalf("error context = %+v", got)
+	}
+	if !errors.Is(gotErr, dropped) {
+		t.Fatalf("error = %v, want middleware error", gotErr)
+	}
+	if text != gotErr.Error() {
+		t.Fatalf("string handler got %q", text)
+	}
+
+	c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+		return dropped
+	})
+	if err := c.TryEmit("send", 1); !errors.Is(err, dropped) {
+		t.Fatalf("TryEmit = %v", err)
+	}
+	if got.Event != "send" || got.Namespace != "chat" {
+		t.Fatalf("outgoing error context = %+v", got)
+	}
+}
+
+func TestListenersInOrder(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var calls []int
+	for i := 1; i <= 3; i++ {
+		i := i
+		c.On("say", func(c *Channel) { calls = append(calls, i) })
+	}
+	if n := c.ListenerCount("say"); n != 3 {
+		t.Fatalf("ListenerCount = %d, want 3", n)
+	}
+	receive(c, `42["say"]`)
+	if len(calls) != 3 || calls[0] != 1 || calls[2] != 3 {
+		t.Fatalf("calls = %v", calls)
+	}
+}
+
+func TestOnce(t *testing.T) {
+	c := newTestClient(ConConf{})
+	calls := 0
+	c.Once("say", func(c *Channel) { calls++ })
+	receive(c, `42["say"]`)
+	receive(c, `42["say"]`)
+	if calls != 1 {
+		t.Fatalf("Once listener called %d times", calls)
+	}
+	if n := c.ListenerCount("say"); n != 0 {
+		t.Fatalf("ListenerCount after Once fired = %d", n)
+	}
+}
+
+func TestOff(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var calls []string
+	sub, _ := c.On("say", func(c *Channel) { calls = append(calls, "removed") })
+	c.On("say", func(c *Channel) { calls = append(calls, "kept") })
+
+	if !c.Off("say", sub) {
+		t.Fatal("Off returned false for a listener")
+	}
+	if c.Off("say", sub) {
+		t.Fatal("Off returned true for a removed listener")
+	}
+	receive(c, `42["say"]`)
+	if len(calls) != 1 || calls[0] != "kept" {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	c.RemoveAllListeners("say")
+	if n := c.ListenerCount("say"); n != 0 {
+		t.Fatalf("ListenerCount after RemoveAllListeners = %d", n)
+	}
+}
+
+func TestOffDuringDispatch(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var sub Subscription
+	calls := 0
+	sub, _ = c.On("say", func(ch *Channel) {
+		calls++
+		c.Off("say", sub)
+	})
+	receive(c, `42["say"]`)
+	receive(c, `42["say"]`)
+	if calls != 1 {
+		t.Fatalf("listener removing itself called %d times", calls)
+	}
+}
+
+func TestPanicsReported(t *testing.T) {
+	var reported []EventInfo
+	var errs []error
+	c := newTestClient(ConConf{OnHandlerError: func(info EventInfo, err error) {
+		reported = append(reported, info)
+		errs = append(errs, err)
+	}})
+	raised := false
+	c.On(OnError, func(c *Channel, err error) { raised = true })
+
+	c.On("handler", func(c *Channel) { panic("handler") })
+	receive(c, `42["handler"]`)
+
+	sub := c.OnAny(func(event string, args []json.RawMessage) { panic("listener") })
+	anyCalled := false
+	c.OnAny(func(event string, args []json.RawMessage) { anyCalled = true })
+	receive(c, `42["listener"]`)
+	c.OffAny(sub)
+	if !anyCalled {
+		t.Fatal("listener after a panicking one not called")
+	}
+
+	c.UseIncoming(func(ctx context.Context, p *Packet, next NextFunc) error { panic("incoming") })
+	receive(c, `42["incoming"]`)
+
+	c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error { panic("outgoing") })
+	err := c.TryEmit("outgoing", 1)
+	var panicked *HandlerPanicError
+	if !errors.As(err, &panicked) {
+		t.Fatalf("TryEmit with panicking middleware = %v", err)
+	}
+
+	want := []string{"handler", "listener", "incoming", "outgoing"}
+	if len(reported) != len(want) {
+		t.Fatalf("reported %v: %v", reported, errs)
+	}
+	for i, event := range want {
+		if reported[i].Event != event || !errors.As(errs[i], &panicked) || panicked.Value != event {
+			t.Fatalf("report %d = %+v %v, want panic of %q", i, reported[i], errs[i], event)
+		}
+	}
+	if raised {
+		t.Fatal("panic raised to OnError as well")
+	}
+}
+
+func TestAckOnlyWithResult(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.On("nothing", func(c *Channel) {})
+	c.On("nil error", func(c *Channel) error { return nil })
+	c.On("value", func(c *Channel) int { return 7 })
+	c.On("error", func(c *Channel) error { return errors.New("failed") })
+
+	for _, event := range []string{"nothing", "nil error", "unhandled"} {
+		receive(c, `42/chat,1["`+event+`"]`)
+		if got := queued(c); len(got) != 0 {
+			t.Fatalf("%s acknowledged with %v", event, got)
+		}
+	}
+	receive(c, `42/chat,2["value"]`)
+	receive(c, `42/chat,3["error"]`)
+	want := []string{`43/chat,2[7]`, `43/chat,3[{"error":"failed"}]`}
+	if got := queued(c); !reflect.DeepEqual(got, want) {
+		t.Fatalf("acks = %v, want %v", got, want)
+	}
+}
@@ -1,12 +1,14 @@
 package socketioclient
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/liangsqrt/socketio-client-go/protocol"
@@ -14,14 +16,16 @@ import (
 )
 
 const (
-	queueBufferSize = 10000
+	queueBufferSize   = 10000
+	defaultWriteBatch = 64
 )
 
 var (
-	ErrorWrongHeader = errors.New("Wrong header")
-	ReconnectChan    = make(chan int, 10)
-	ReconnectCnt     = 10
-	ReconnectCntLock = sync.Mutex{}
+	ErrorWrongHeader   = errors.New("Wrong header")
+	ErrorUpgradeFailed = errors.New("failed in handshake message")
+	ReconnectChan      = make(chan int, 10)
+	ReconnectCnt       = 10
+	ReconnectCntLock   = sync.Mutex{}
 )
 
 /*
@@ -46,28 +50,110 @@ Close message means channel is closed
 ping is automatic
 */
 type Channel struct {
-	conn transport.Connection
-
-	out    chan string
-	header Header
+	// replaced on reconnect, guarded by connLock
+	conn     transport.Connection
+	connLock sync.Mutex
+	// closed when conn is replaced or the channel closes
+	connChanged chan struct{}
+
+	// bulk lane and high priority lane for control packets, outLoop
+	// writes queued high priority packets first
+	out     chan frame
+	outHigh chan frame
+	header  Header
 
 	alive     bool
 	aliveLock sync.Mutex
 
-	//ack ackProcessor
+	metrics     Metrics
+	tracer      Tracer
+	middlewares middlewares
+
+	anyListeners anyListeners
+
+	// replaced on every connect, guarded by sessionLock
+	sessionLock sync.Mutex
+	dispatcher  *dispatcher
+	// cancelled when the connection is closed or lost
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	onHandlerError func(info EventInfo, err error)
+	// EngineIOv3 or EngineIOv4, zero means EngineIOv4
+	protocolVersion int
+	// socket.io packet encoding, protocol.JSONParser if nil
+	packetParser protocol.Parser
+	// maximum packets per outLoop write, defaultWriteBatch if 0
+	writeBatch int
+
+	queueSize      int
+	queueHighWater int
+	onHighWater    func(depth int, high bool)
+	// queue depth above which volatile emits are dropped
+	volatileThreshold int
+	// nil without rate limit
+	limiter *rateLimiter
+	// emitted through the high priority lane
+	highPriorityEvents map[string]bool
+	// queue depth reached queueHighWater and did not drain yet
+	overflooded atomic.Bool
+
+	// unix nano time of the last server ping still waiting for its pong
+	pingAt atomic.Int64
+
+	// emits waiting for an ack of the server
+	acks ackTable
 
 	//server  *Server
 	ip      string
 	request *http.Request
 }
 
+/*
+*
+Copy connection settings of conf to the channel
+*/
+func (c *Channel) configure(conf ConConf) {
+	c.metrics = conf.Metrics
+	c.tracer = conf.Tracer
+	c.onHandlerError = conf.OnHandlerError
+	c.packetParser = conf.Parser
+	c.writeBatch = conf.WriteBatch
+	c.protocolVersion = conf.ProtocolVersion
+
+	c.queueSize = conf.QueueSize
+	if c.queueSize <= 0 {
+		c.queueSize = queueBufferSize
+	}
+	c.queueHighWater = conf.QueueHighWater
+	if c.queueHighWater <= 0 || c.queueHighWater > c.queueSize {
+		c.queueHighWater = c.queueSize / 2
+	}
+	c.onHighWater = conf.OnQueueHighWater
+	c.volatileThreshold = conf.VolatileThreshold
+	if c.volatileThreshold <= 0 {
+		c.volatileThreshold = c.queueHighWater
+	}
+	c.limiter = newRateLimiter(conf)
+	c.highPriorityEvents = nil
+	for _, event := range conf.HighPriorityEvents {
+		if c.highPriorityEvents == nil {
+			c.highPriorityEvents = make(map[string]bool)
+		}
+		c.highPriorityEvents[event] = true
+	}
+}
+
 /*
 *
 create channel, map, and set active
 */
 func (c *Channel) initChannel() {
-	//TODO: queueBufferSize from constant to server or client variable
-	c.out = make(chan string, queueBufferSize)
+	if c.queueSize <= 0 {
+		c.queueSize = queueBufferSize
+	}
+	c.out = make(chan frame, c.queueSize)
+	c.outHigh = make(chan frame, c.queueSize)
 	//c.ack.resultWaiters = make(map[int](chan string))
 	c.setAliveValue(true)
 }
@@ -100,6 +186,145 @@ func (c *Channel) IsAlive() bool {
 	return isAlive
 }
 
+/*
+*
+Current connection
+*/
+func (c *Channel) connection() transport.Connection {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	return c.conn
+}
+
+/*
+*
+Current connection and a channel closed once it is replaced or the channel
+closes
+*/
+func (c *Channel) watchConnection() (transport.Connection, <-chan struct{}) {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	if c.connChanged == nil {
+		c.connChanged = make(chan struct{})
+	}
+	return c.conn, c.connChanged
+}
+
+/*
+*
+Replace the connection and wake whoever watches it
+*/
+func (c *Channel) setConnection(conn transport.Connection) {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	c.conn = conn
+	c.connectionChanged()
+}
+
+/*
+*
+Wake connection watchers, connLock must be held
+*/
+func (c *Channel) connectionChanged() {
+	if c.connChanged != nil {
+		close(c.connChanged)
+		c.connChanged = nil
+	}
+}
+
+/*
+*
+Whether conn signalled it is lost, the reconnect loop then replaces it
+*/
+func connectionLost(conn transport.Connection) bool {
+	rc, ok := conn.(transport.ReconnectConnection)
+	if !ok {
+		return false
+	}
+	select {
+	case <-rc.ReConnectChan():
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+*
+Metrics sink of the channel, never nil
+*/
+func (c *Channel) stats() Metrics {
+	if c.metrics == nil {
+		return noopMetrics{}
+	}
+	return c.metrics
+}
+
+/*
+*
+Tracer of the channel, never nil
+*/
+func (c *Channel) trace() Tracer {
+	if c.tracer == nil {
+		return noopTracer{}
+	}
+	return c.tracer
+}
+
+/*
+*
+Parser of the channel, never nil
+*/
+func (c *Channel) parser() protocol.Parser {
+	if c.packetParser == nil {
+		return protocol.JSONParser{}
+	}
+	return c.packetParser
+}
+
+/*
+*
+Hand task to the dispatcher of the current connection
+*/
+func (c *Channel) dispatch(key string, task func()) {
+	c.sessionLock.Lock()
+	d := c.dispatcher
+	c.sessionLock.Unlock()
+	if d != nil {
+		d.dispatch(key, task)
+	}
+}
+
+/*
+*
+End the current connection: cancel its context and stop the dispatcher
+workers, packets still queued are dropped
+*/
+func (c *Channel) endSession() {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.dispatcher != nil {
+		c.dispatcher.stop()
+		c.dispatcher = nil
+	}
+}
+
+/*
+*
+Report error returned by a handler, or its panic
+*/
+func (c *Channel) handlerError(ctx context.Context, err error) {
+	info, _ := EventFromContext(ctx)
+	if c.onHandlerError == nil {
+		log.Printf("socket.io handler for %q failed: %v", info.Event, err)
+		return
+	}
+	c.onHandlerError(info, err)
+}
+
 func (c *Channel) setAliveValue(value bool) {
 	c.aliveLock.Lock()
 	c.alive = value
@@ -111,20 +336,39 @@ func (c *Channel) setAliveValue(value bool) {
 Close channel
 */
 func closeChannel(c *Channel, m *methods, args ...interface{}) error {
+	// the read loop, the write loop and Close may all get here
+	c.aliveLock.Lock()
+	if !c.alive {
+		c.aliveLock.Unlock()
+		return nil
+	}
+	c.alive = false
+	c.aliveLock.Unlock()
+
 	log.Println("Channel closed - calling disconnect")
-	c.conn.Close()
-	c.setAliveValue(false)
+	c.connLock.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	// the reconnect and write loops stop waiting for a new connection
+	c.connectionChanged()
+	c.connLock.Unlock()
+	c.endSession()
 
 	//clean outloop
 	for len(c.out) > 0 {
-		<-c.out
+		(<-c.out).release()
+	}
+	for len(c.outHigh) > 0 {
+		(<-c.outHigh).release()
 	}
 
-	deleteOverflooded(c)
-	f, _ := m.findMethod("disconnection")
-	m.initMethods()
-	if f != nil {
-		f.callFunc(c, &struct{}{})
+	callers := m.findMethod(OnDisconnection)
+	m.clearMethods()
+	for _, f := range callers {
+		if _, err := f.safeCallFunc(c.Context(), c, &struct{}{}); err != nil {
+			c.handlerError(c.Context(), err)
+		}
 	}
 
 	return nil
@@ -132,62 +376,213 @@ func closeChannel(c *Channel, m *methods, args ...interface{}) error {
 
 // incoming messages loop, puts incoming messages to In channel
 func inLoop(c *Channel, m *methods) error {
+	conn := c.connection()
 	for {
-		pkg, err := c.conn.GetMessage()
+		data, binary, err := transport.GetFrame(conn)
 		if err != nil {
+			if c.connection() != conn || connectionLost(conn) {
+				// the reconnect loop closes the lost connection, the new
+				// one gets its own read loop
+				return nil
+			}
 			return closeChannel(c, m, err)
 		}
+		if data == nil {
+			// the transport is reconnecting, the loop is started again
+			// for the new connection
+			return nil
+		}
+		if binary {
+			// binary frames are socket.io packets of the parser, decoded
+			// here so they are dispatched by event name
+			c.stats().AddCounter(MetricBytesReceived, float64(len(data)), nil)
+			msg, err := c.parser().Decode(data, true)
+			if err != nil {
+				continue
+			}
+			// acks wake emits that handlers may be waiting in
+			if msg.SocketType == protocol.SocketMessageTypeAck {
+				m.processDecodedMessage(c, msg)
+				continue
+			}
+			c.dispatch(msg.SocketEvent.EventName, func() {
+				m.processDecodedMessage(c, msg)
+			})
+			continue
+		}
+		pkg := string(data)
 		engineIoType, err := protocol.GetEngineMessageType(pkg)
 		// log.Println("Engine IO type: " + engineIoType.String())
 		if err != nil {
 			return closeChannel(c, m, protocol.ErrorWrongPacket)
 		}
-		go m.processIncomingMessage(c, engineIoType, pkg)
+		c.stats().AddCounter(MetricBytesReceived, float64(len(pkg)), nil)
+		// heartbeat, close and acks must not wait behind handlers, which
+		// may be waiting for an ack themselves
+		if engineIoType == protocol.EngineMessageTypePing || engineIoType == protocol.EngineMessageTypePong ||
+			engineIoType == protocol.EngineMessageTypeClose || isAck(engineIoType, pkg) {
+			m.processIncomingMessage(c, engineIoType, pkg)
+			continue
+		}
+		c.dispatch(protocol.GetEventName(pkg), func() {
+			m.processIncomingMessage(c, engineIoType, pkg)
+		})
 	}
 
 }
 
-var overflooded sync.Map
+func isAck(engineIoType protocol.EngineMessageType, pkg string) bool {
+	if engineIoType != protocol.EngineMessageTypeMessage {
+		return false
+	}
+	socketType, err := protocol.GetSocketMessageType(pkg)
+	return err == nil && socketType == protocol.SocketMessageTypeAck
+}
 
-func deleteOverflooded(c *Channel) {
-	overflooded.Delete(c)
+/*
+*
+Packets waiting in the outbound queue, both lanes
+*/
+func (c *Channel) QueueLen() int {
+	return len(c.out) + len(c.outHigh)
 }
 
-func storeOverflow(c *Channel) {
-	overflooded.Store(c, struct{}{})
+/*
+*
+Whether the outbound queue reached its high-water mark and did not drain
+to half of it yet
+*/
+func (c *Channel) Overflooded() bool {
+	return c.overflooded.Load()
 }
 
 /*
 *
-outgoing messages loop, sends messages from channel to socket
+Leave the overflooded state once the queue drained to half the high-water mark
+*/
+func (c *Channel) checkDrained(depth int) {
+	if depth <= c.queueHighWater/2 && c.overflooded.CompareAndSwap(true, false) {
+		if c.onHighWater != nil {
+			c.onHighWater(depth, false)
+		}
+	}
+}
+
+/*
+*
+outgoing messages loop, sends messages from channel to socket. Packets
+already queued are taken along, up to writeBatch, and flushed together
 */
 func outLoop(c *Channel, m *methods) error {
+	limit := c.writeBatch
+	if limit <= 0 {
+		limit = defaultWriteBatch
+	}
+	msgs := make([]frame, 0, limit)
+	frames := make([]transport.Frame, 0, limit)
 	for {
-		outBufferLen := len(c.out)
-		if outBufferLen >= queueBufferSize-1 {
-			return closeChannel(c, m, ErrorSocketOverflood)
-		} else if outBufferLen > int(queueBufferSize/2) {
-			storeOverflow(c)
-		} else {
-			deleteOverflooded(c)
+		outBufferLen := c.QueueLen()
+		c.checkDrained(outBufferLen)
+		c.stats().SetGauge(MetricOutboundQueueDepth, float64(outBufferLen), nil)
+
+		var first frame
+		select {
+		case first = <-c.outHigh:
+		default:
+			select {
+			case first = <-c.outHigh:
+			case first = <-c.out:
+			}
+		}
+		msgs = append(msgs[:0], first)
+		// high priority packets queued meanwhile go before bulk ones
+	drain:
+		for len(msgs) < limit {
+			select {
+			case msg := <-c.outHigh:
+				msgs = append(msgs, msg)
+				continue
+			default:
+			}
+			select {
+			case msg := <-c.out:
+				msgs = append(msgs, msg)
+			default:
+				break drain
+			}
 		}
 
-		msg := <-c.out
-
-		err := c.conn.WriteMessage(msg)
+		frames = frames[:0]
+		for _, msg := range msgs {
+			frames = append(frames, transport.Frame{Data: msg.data, Binary: msg.binary})
+		}
+		err := writeFrames(c, frames)
 		if err != nil {
+			for _, msg := range msgs {
+				msg.release()
+			}
 			return closeChannel(c, m, err)
 		}
+		for i, msg := range msgs {
+			c.stats().AddCounter(MetricBytesSent, float64(len(msg.data)), nil)
+			if !msg.binary && string(msg.data) == protocol.PingMessage {
+				observeHeartbeat(c)
+			}
+			msg.release()
+			msgs[i] = frame{}
+		}
+		c.stats().AddCounter(MetricWriteFlushes, 1, nil)
+	}
+}
 
+/*
+*
+Write frames, when the transport reconnects the frames not sent yet are
+written again on the new connection
+*/
+func writeFrames(c *Channel, frames []transport.Frame) error {
+	for {
+		conn, changed := c.watchConnection()
+		err := transport.WriteFrames(conn, frames)
+		var unsent *transport.UnsentFramesError
+		if !errors.Is(err, transport.ErrorReconnecting) || !errors.As(err, &unsent) {
+			return err
+		}
+		frames = unsent.Frames
+		// the reconnect loop replaces the connection, or closes the channel
+		// when it gives up
+		<-changed
+		if !c.IsAlive() {
+			return ErrorSocketClosed
+		}
 	}
 }
 
 /*
 *
-Pinger sends ping messages for keeping connection alive
+Record the heartbeat round trip. Engine.IO v4 servers drive the heartbeat,
+so there this is the time between a server ping and our pong leaving the
+socket, with Engine.IO v3 it is the time between our ping and the server pong
+*/
+func observeHeartbeat(c *Channel) {
+	pingAt := c.pingAt.Swap(0)
+	if pingAt == 0 {
+		return
+	}
+	rtt := time.Since(time.Unix(0, pingAt))
+	c.stats().ObserveHistogram(MetricHeartbeatRTT, rtt.Seconds(), nil)
+}
+
+/*
+*
+Pinger sends ping messages for keeping connection alive. Engine.IO v3 servers
+expect them every pingInterval of the handshake
 */
 func pinger(c *Channel) {
-	interval, _ := c.conn.PingParams()
+	interval, _ := c.connection().PingParams()
+	if c.protocolVersion == EngineIOv3 && c.header.PingInterval > 0 {
+		interval = time.Duration(c.header.PingInterval) * time.Millisecond
+	}
 	ticker := time.NewTicker(interval)
 
 	for {
@@ -195,27 +590,63 @@ func pinger(c *Channel) {
 		if !c.IsAlive() {
 			return
 		}
-		c.out <- protocol.PingMessage
+		if c.protocolVersion == EngineIOv3 {
+			// Engine.IO v3 clients ping and the server answers with a pong
+			c.pingAt.Store(time.Now().UnixNano())
+			heartbeat(c, protocol.PongMessage)
+			continue
+		}
+		heartbeat(c, protocol.PingMessage)
+	}
+}
+
+/*
+*
+Queue a heartbeat in the high priority lane, waiting for room until the
+connection closes so that a busy lane delays it instead of skipping it
+*/
+func heartbeat(c *Channel, msg string) {
+	if err := enqueue(c.Context(), textFrame(msg), c, queueBlock, true); err != nil {
+		controlDropped(c, "ping", err)
 	}
 }
 
 /*
 *
-Handle the upgrade process
+Handle the upgrade process of conn before it becomes the channel connection.
+On error conn is closed, no disconnect handler runs
 */
-func handleUpgrade(c *Channel, m *methods) error {
+func handleUpgrade(ctx context.Context, c *Channel, conn transport.Connection) (err error) {
+	// unblock GetMessage/WriteMessage when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		// close once, unless ctx did it already
+		if stop() && err != nil {
+			conn.Close()
+		}
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
 	// Step 1: Send 2probe message
 
 	msgList := []string{"2probe", "5"}
 	for _, msg := range msgList {
-		err := c.conn.WriteMessage(msg)
-		if err != nil {
+		if err := conn.WriteMessage(msg); err != nil {
 			return err
 		}
+		// Engine.IO v3 servers send nothing after the upgrade, they wait for our ping
+		if msg == "5" && c.protocolVersion == EngineIOv3 {
+			return nil
+		}
 		// Step 2: Wait for 3probe response
-		ansMsg, err := c.conn.GetMessage()
-		if err != nil || !(ansMsg == "3probe" || ansMsg == "6" || ansMsg == "2" || strings.HasPrefix(ansMsg, "40")) {
-			return closeChannel(c, m, errors.New("failed in handshake message"))
+		ansMsg, err := conn.GetMessage()
+		if err != nil {
+			return err
+		}
+		if !(ansMsg == "3probe" || ansMsg == "6" || ansMsg == "2" || strings.HasPrefix(ansMsg, "40")) {
+			return ErrorUpgradeFailed
 		}
 
 	}
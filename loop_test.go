@@ -0,0 +1,347 @@
+package socketioclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+	"github.com/liangsqrt/socketio-client-go/transport"
+)
+
+/*
+*
+Connection answering reads from replies, a read blocks while replies is
+empty until the connection is closed
+*/
+type fakeConn struct {
+	lock     sync.Mutex
+	replies  chan string
+	written  []string
+	writeErr error
+	closed   chan struct{}
+	closes   int
+}
+
+func newFakeConn(replies ...string) *fakeConn {
+	conn := &fakeConn{replies: make(chan string, 100), closed: make(chan struct{})}
+	for _, reply := range replies {
+		conn.replies <- reply
+	}
+	return conn
+}
+
+func (f *fakeConn) GetMessage() (string, error) {
+	select {
+	case reply := <-f.replies:
+		return reply, nil
+	case <-f.closed:
+		return "", errors.New("closed")
+	}
+}
+
+func (f *fakeConn) WriteMessage(message string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	f.written = append(f.written, message)
+	return nil
+}
+
+func (f *fakeConn) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closes++
+	if f.closes == 1 {
+		close(f.closed)
+	}
+}
+
+func (f *fakeConn) PingParams() (time.Duration, time.Duration) {
+	return time.Hour, time.Hour
+}
+
+func (f *fakeConn) closeCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.closes
+}
+
+/*
+*
+Transport implementing only the required methods, without context support
+*/
+type fakeTransport struct {
+	conn *fakeConn
+}
+
+func (t *fakeTransport) Handshake(url string, namespace string) (string, error) {
+	return "sid", nil
+}
+
+func (t *fakeTransport) Connect(url string) (transport.Connection, error) {
+	return t.conn, nil
+}
+
+func (t *fakeTransport) HandleConnection(w http.ResponseWriter, r *http.Request) (transport.Connection, error) {
+	return nil, errors.New("not supported")
+}
+
+func (t *fakeTransport) Serve(w http.ResponseWriter, r *http.Request) {}
+
+/*
+*
+Connection signalling its loss like transport.WebsocketConnection
+*/
+type losableConn struct {
+	*fakeConn
+	lost chan struct{}
+}
+
+func newLosableConn(replies ...string) *losableConn {
+	return &losableConn{fakeConn: newFakeConn(replies...), lost: make(chan struct{})}
+}
+
+func (l *losableConn) ReConnectChan() <-chan struct{} {
+	return l.lost
+}
+
+/*
+*
+Transport handing out conns in order, then refusing to connect
+*/
+type reconnectTransport struct {
+	fakeTransport
+	lock  sync.Mutex
+	conns []transport.Connection
+}
+
+func (t *reconnectTransport) Connect(url string) (transport.Connection, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.conns) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	conn := t.conns[0]
+	t.conns = t.conns[1:]
+	return conn, nil
+}
+
+func TestHandleUpgrade(t *testing.T) {
+	c := newTestClient(ConConf{})
+	conn := newFakeConn("3probe", "6")
+	if err := handleUpgrade(context.Background(), &c.Channel, conn); err != nil {
+		t.Fatal(err)
+	}
+	if conn.closeCount() != 0 {
+		t.Fatal("connection closed after upgrade")
+	}
+	if len(conn.written) != 2 || conn.written[0] != "2probe" || conn.written[1] != "5" {
+		t.Fatalf("written = %v", conn.written)
+	}
+}
+
+func TestHandleUpgradeClosesOnce(t *testing.T) {
+	writeErr := errors.New("write failed")
+	tests := []struct {
+		name    string
+		conn    *fakeConn
+		timeout time.Duration
+		want    error
+	}{
+		{"write error", &fakeConn{replies: make(chan string), closed: make(chan struct{}), writeErr: writeErr}, time.Hour, writeErr},
+		{"unexpected reply", newFakeConn("4"), time.Hour, ErrorUpgradeFailed},
+		{"cancelled", newFakeConn(), 10 * time.Millisecond, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(ConConf{})
+			disconnected := false
+			c.On(OnDisconnection, func(ch *Channel) { disconnected = true })
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			err := handleUpgrade(ctx, &c.Channel, tt.conn)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("handleUpgrade = %v, want %v", err, tt.want)
+			}
+			if n := tt.conn.closeCount(); n != 1 {
+				t.Fatalf("connection closed %d times", n)
+			}
+			if disconnected {
+				t.Fatal("disconnect handler called for a connection never established")
+			}
+		})
+	}
+}
+
+func TestDialWithoutContextTransport(t *testing.T) {
+	tr := &fakeTransport{conn: newFakeConn("3probe", "6")}
+	c, err := Dial(ConConf{Host: "localhost", Port: 80}, tr, &Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Namespace.Sid != "sid" {
+		t.Fatalf("sid = %q", c.Namespace.Sid)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := DialContext(ctx, ConConf{Host: "localhost", Port: 80}, tr, &Namespace{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DialContext with cancelled ctx = %v", err)
+	}
+}
+
+func TestHandleUpgradeV3(t *testing.T) {
+	c := newTestClient(ConConf{ProtocolVersion: EngineIOv3})
+	// nothing follows the probe, Engine.IO v3 servers do not answer the upgrade
+	conn := newFakeConn("3probe")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := handleUpgrade(ctx, &c.Channel, conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.written) != 2 || conn.written[0] != "2probe" || conn.written[1] != "5" {
+		t.Fatalf("written = %v", conn.written)
+	}
+}
+
+func TestPingerV3UsesServerInterval(t *testing.T) {
+	c := newTestClient(ConConf{ProtocolVersion: EngineIOv3})
+	// the connection asks for an hour, the server for 10ms
+	c.conn = newFakeConn()
+	c.connParams().OnOpen(`{"sid":"abc","pingInterval":10,"pingTimeout":5000}`)
+	if c.header.PingInterval != 10 {
+		t.Fatalf("pingInterval = %d", c.header.PingInterval)
+	}
+	c.setAliveValue(true)
+	defer c.setAliveValue(false)
+	go pinger(&c.Channel)
+
+	select {
+	case f := <-c.outHigh:
+		if string(f.data) != protocol.PongMessage {
+			t.Fatalf("pinger sent %q", f.data)
+		}
+	case f := <-c.out:
+		if string(f.data) != protocol.PongMessage {
+			t.Fatalf("pinger sent %q", f.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no ping within a second")
+	}
+}
+
+/*
+*
+Connection lost after its first write, failed is closed on the first failing write
+*/
+type droppedConn struct {
+	*fakeConn
+	failed     chan struct{}
+	failedOnce sync.Once
+}
+
+func (d *droppedConn) WriteMessage(message string) error {
+	d.lock.Lock()
+	sent := len(d.written)
+	d.lock.Unlock()
+	if sent == 1 {
+		d.failedOnce.Do(func() { close(d.failed) })
+		return transport.ErrorReconnecting
+	}
+	return d.fakeConn.WriteMessage(message)
+}
+
+func TestOutLoopResendsUnsentFramesAfterReconnect(t *testing.T) {
+	c := newTestClient(ConConf{})
+	next := newFakeConn()
+	lost := &droppedConn{fakeConn: newFakeConn(), failed: make(chan struct{})}
+	c.conn = lost
+	c.setAliveValue(true)
+	// what the reconnect loop does
+	go func() {
+		<-lost.failed
+		c.setConnection(next)
+	}()
+
+	for _, event := range []string{"a", "b", "c"} {
+		if err := c.TryEmit(event, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go outLoop(&c.Channel, &c.methods)
+	defer c.setAliveValue(false)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		next.lock.Lock()
+		written := append([]string(nil), next.written...)
+		next.lock.Unlock()
+		if len(written) == 2 {
+			if written[0] != `42/,0["b",1]` || written[1] != `42/,0["c",1]` {
+				t.Fatalf("written on the new connection = %v", written)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("written on the new connection = %v", written)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(lost.written) != 1 || lost.written[0] != `42/,0["a",1]` {
+		t.Fatalf("written on the lost connection = %v", lost.written)
+	}
+}
+
+func TestOutLoopStopsWhenReconnectGivesUp(t *testing.T) {
+	c := newTestClient(ConConf{})
+	lost := &droppedConn{fakeConn: newFakeConn(), failed: make(chan struct{})}
+	c.conn = lost
+	c.setAliveValue(true)
+	for _, event := range []string{"a", "b"} {
+		if err := c.TryEmit(event, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- outLoop(&c.Channel, &c.methods) }()
+
+	<-lost.failed
+	closeChannel(&c.Channel, &c.methods)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("outLoop still waiting for a new connection after the channel closed")
+	}
+}
+
+func TestReconnectGivesUpAndCloses(t *testing.T) {
+	first := newLosableConn("3probe", "6")
+	tr := &reconnectTransport{conns: []transport.Connection{first}}
+	c, err := Dial(ConConf{Host: "localhost", Port: 80, AutoReconnect: true, ReconnectMax: 2}, tr, &Namespace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disconnected := make(chan struct{})
+	c.On(OnDisconnection, func(ch *Channel) { close(disconnected) })
+
+	close(first.lost)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("disconnect handler not called after the last reconnect attempt")
+	}
+	if c.IsAlive() {
+		t.Fatal("client alive after the reconnect gave up")
+	}
+	if first.closeCount() == 0 {
+		t.Fatal("lost connection not closed")
+	}
+}
@@ -0,0 +1,58 @@
+package socketioclient
+
+const (
+	MetricMessagesReceived   = "socketio_messages_received_total"
+	MetricMessagesSent       = "socketio_messages_sent_total"
+	MetricBytesReceived      = "socketio_received_bytes_total"
+	MetricBytesSent          = "socketio_sent_bytes_total"
+	MetricOutboundQueueDepth = "socketio_outbound_queue_depth"
+	MetricReconnectAttempts  = "socketio_reconnect_attempts_total"
+	MetricReconnects         = "socketio_reconnects_total"
+	MetricHeartbeatRTT       = "socketio_heartbeat_rtt_seconds"
+	// time between an EmitWithAck and the ack of the server
+	MetricAckLatency = "socketio_ack_latency_seconds"
+	// packets that found the outbound queue full
+	MetricQueueFull = "socketio_outbound_queue_full_total"
+	// pongs, pings, acks and CONNECT packets dropped because the high
+	// priority lane was full, labelled with the packet
+	MetricControlDropped = "socketio_control_dropped_total"
+	// volatile emits dropped because the connection was not writable
+	MetricVolatileDropped = "socketio_volatile_dropped_total"
+	// emits over the rate limit, labelled with the action taken:
+	// delayed, dropped or rejected
+	MetricThrottled = "socketio_emits_throttled_total"
+	// wait of delayed emits
+	MetricThrottleDelay = "socketio_emit_throttle_delay_seconds"
+	// incoming packets dropped because their dispatch queue was full
+	MetricDispatchDropped = "socketio_dispatch_dropped_total"
+	// network flushes of the outbound loop, each carrying one or more packets
+	MetricWriteFlushes = "socketio_write_flushes_total"
+)
+
+/*
+*
+Metrics receives counters, gauges and histogram observations about the
+connection and message flow. Names are the Metric* constants, labels may be nil.
+
+Per event metrics carry the event name in the "event" label. Event names come
+from the application and the server, so implementations exporting labels as
+series should bound them, see prometheus.Metrics.LimitEvents
+
+Implementations must be safe for concurrent use, see the metrics/prometheus
+package for a Prometheus adapter
+*/
+type Metrics interface {
+	AddCounter(name string, delta float64, labels map[string]string)
+	SetGauge(name string, value float64, labels map[string]string)
+	ObserveHistogram(name string, value float64, labels map[string]string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) AddCounter(name string, delta float64, labels map[string]string)       {}
+func (noopMetrics) SetGauge(name string, value float64, labels map[string]string)         {}
+func (noopMetrics) ObserveHistogram(name string, value float64, labels map[string]string) {}
+
+func eventLabels(event string) map[string]string {
+	return map[string]string{"event": event}
+}
@@ -0,0 +1,210 @@
+/*
+Package prometheus adapts socketioclient.Metrics to the Prometheus client library.
+
+	reg := prom.NewRegistry()
+	conf.Metrics = prometheus.New(reg)
+*/
+package prometheus
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// value of the event label for events over the limit set by LimitEvents
+const OtherEvent = "_other"
+
+/*
+*
+Metrics creates Prometheus collectors lazily, one per metric name. The label
+names of a metric are fixed by the first observation of that metric.
+
+The event label carries the event name, which is chosen by the server. Use
+LimitEvents to bound the number of series when event names are not a small
+fixed set.
+
+A metric whose registration fails, e.g. because its name is taken by a
+collector with other labels, is reported to the error handler and dropped
+*/
+type Metrics struct {
+	reg     prom.Registerer
+	buckets []float64
+	// called with registration errors, logs them if nil
+	onError func(err error)
+
+	// event label values, nil means unlimited
+	events    map[string]bool
+	maxEvents int
+
+	mu         sync.Mutex
+	counters   map[string]*prom.CounterVec
+	gauges     map[string]*prom.GaugeVec
+	histograms map[string]*prom.HistogramVec
+}
+
+/*
+*
+Create adapter registering its collectors in reg, nil means the default registerer
+*/
+func New(reg prom.Registerer) *Metrics {
+	if reg == nil {
+		reg = prom.DefaultRegisterer
+	}
+	return &Metrics{
+		reg:        reg,
+		buckets:    prom.DefBuckets,
+		counters:   make(map[string]*prom.CounterVec),
+		gauges:     make(map[string]*prom.GaugeVec),
+		histograms: make(map[string]*prom.HistogramVec),
+	}
+}
+
+/*
+*
+Set histogram buckets for histograms not created yet
+*/
+func (m *Metrics) SetBuckets(buckets []float64) {
+	m.mu.Lock()
+	m.buckets = buckets
+	m.mu.Unlock()
+}
+
+/*
+*
+Call f with registration errors instead of logging them. Collectors are
+registered on the first observation of a metric, from the read and write
+loops of the client, so f must not block
+*/
+func (m *Metrics) SetErrorHandler(f func(err error)) {
+	m.mu.Lock()
+	m.onError = f
+	m.mu.Unlock()
+}
+
+/*
+*
+Keep at most max distinct event label values, plus the allowed events. The
+other events are reported as OtherEvent. Call it before the first observation
+*/
+func (m *Metrics) LimitEvents(max int, allowed ...string) {
+	m.mu.Lock()
+	m.events = make(map[string]bool, len(allowed))
+	for _, event := range allowed {
+		m.events[event] = true
+	}
+	m.maxEvents = max + len(m.events)
+	m.mu.Unlock()
+}
+
+/*
+*
+Replace an event label over the limit, copying labels as the caller may reuse them
+*/
+func (m *Metrics) limitLabels(labels map[string]string) map[string]string {
+	event, ok := labels["event"]
+	if !ok || m.events == nil || m.events[event] {
+		return labels
+	}
+	if len(m.events) < m.maxEvents {
+		m.events[event] = true
+		return labels
+	}
+	limited := make(map[string]string, len(labels))
+	for name, value := range labels {
+		limited[name] = value
+	}
+	limited["event"] = OtherEvent
+	return limited
+}
+
+func (m *Metrics) AddCounter(name string, delta float64, labels map[string]string) {
+	m.mu.Lock()
+	labels = m.limitLabels(labels)
+	vec, ok := m.counters[name]
+	if !ok {
+		vec = register(m, prom.NewCounterVec(prom.CounterOpts{Name: name, Help: name}, labelNames(labels)))
+		m.counters[name] = vec
+	}
+	m.mu.Unlock()
+
+	if vec == nil {
+		return
+	}
+	if c, err := vec.GetMetricWith(labels); err == nil {
+		c.Add(delta)
+	}
+}
+
+func (m *Metrics) SetGauge(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	labels = m.limitLabels(labels)
+	vec, ok := m.gauges[name]
+	if !ok {
+		vec = register(m, prom.NewGaugeVec(prom.GaugeOpts{Name: name, Help: name}, labelNames(labels)))
+		m.gauges[name] = vec
+	}
+	m.mu.Unlock()
+
+	if vec == nil {
+		return
+	}
+	if g, err := vec.GetMetricWith(labels); err == nil {
+		g.Set(value)
+	}
+}
+
+func (m *Metrics) ObserveHistogram(name string, value float64, labels map[string]string) {
+	m.mu.Lock()
+	labels = m.limitLabels(labels)
+	vec, ok := m.histograms[name]
+	if !ok {
+		vec = register(m, prom.NewHistogramVec(prom.HistogramOpts{Name: name, Help: name, Buckets: m.buckets}, labelNames(labels)))
+		m.histograms[name] = vec
+	}
+	m.mu.Unlock()
+
+	if vec == nil {
+		return
+	}
+	if h, err := vec.GetMetricWith(labels); err == nil {
+		h.Observe(value)
+	}
+}
+
+func labelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+*
+Register collector, reusing the one already registered under the same name.
+Other errors are reported to the error handler and give nil, m.mu is held
+*/
+func register[V prom.Collector](m *Metrics, c V) V {
+	err := m.reg.Register(c)
+	if are, ok := err.(prom.AlreadyRegisteredError); ok {
+		if existing, ok := are.ExistingCollector.(V); ok {
+			return existing
+		}
+		err = fmt.Errorf("%w with another type", err)
+	}
+	if err == nil {
+		return c
+	}
+	if m.onError != nil {
+		m.onError(err)
+	} else {
+		log.Println("socket.io metrics:", err)
+	}
+	var none V
+	return none
+}
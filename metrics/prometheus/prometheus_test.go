@@ -0,0 +1,100 @@
+package prometheus
+
+import (
+	"testing"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func counterValues(t *testing.T, reg *prom.Registry, name string) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			event := ""
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "event" {
+					event = label.GetValue()
+				}
+			}
+			values[event] = metric.GetCounter().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCounter(t *testing.T) {
+	reg := prom.NewRegistry()
+	m := New(reg)
+	m.AddCounter("test_total", 1, map[string]string{"event": "a"})
+	m.AddCounter("test_total", 2, map[string]string{"event": "a"})
+
+	if got := counterValues(t, reg, "test_total")["a"]; got != 3 {
+		t.Fatalf("counter = %v, want 3", got)
+	}
+}
+
+func TestReuseRegisteredCollector(t *testing.T) {
+	reg := prom.NewRegistry()
+	New(reg).AddCounter("test_total", 1, map[string]string{"event": "a"})
+	New(reg).AddCounter("test_total", 1, map[string]string{"event": "a"})
+
+	if got := counterValues(t, reg, "test_total")["a"]; got != 2 {
+		t.Fatalf("counter = %v, want 2", got)
+	}
+}
+
+func TestRegisterConflictReported(t *testing.T) {
+	reg := prom.NewRegistry()
+	reg.MustRegister(prom.NewCounterVec(prom.CounterOpts{Name: "test_total", Help: "other help"}, []string{"other"}))
+
+	var errs []error
+	m := New(reg)
+	m.SetErrorHandler(func(err error) { errs = append(errs, err) })
+	m.AddCounter("test_total", 1, map[string]string{"event": "a"})
+	m.AddCounter("test_total", 1, map[string]string{"event": "a"})
+	m.SetGauge("test_total", 1, map[string]string{"event": "a"})
+	// metrics without conflict still work
+	m.AddCounter("other_total", 1, map[string]string{"event": "a"})
+	// same name and labels, another collector type
+	m.SetGauge("other_total", 1, map[string]string{"event": "a"})
+
+	if len(errs) != 3 {
+		t.Fatalf("reported %v, want one error per conflicting metric", errs)
+	}
+	if got := counterValues(t, reg, "other_total")["a"]; got != 1 {
+		t.Fatalf("counter = %v, want 1", got)
+	}
+}
+
+func TestLimitEvents(t *testing.T) {
+	reg := prom.NewRegistry()
+	m := New(reg)
+	m.LimitEvents(2, "login")
+	labels := map[string]string{}
+	for _, event := range []string{"a", "b", "c", "d", "login"} {
+		labels["event"] = event
+		m.AddCounter("test_total", 1, labels)
+	}
+
+	values := counterValues(t, reg, "test_total")
+	want := map[string]float64{"a": 1, "b": 1, "login": 1, OtherEvent: 2}
+	if len(values) != len(want) {
+		t.Fatalf("series = %v, want %v", values, want)
+	}
+	for event, value := range want {
+		if values[event] != value {
+			t.Fatalf("series = %v, want %v", values, want)
+		}
+	}
+	if labels["event"] != "login" {
+		t.Fatalf("caller labels modified: %v", labels)
+	}
+}
@@ -0,0 +1,126 @@
+package socketioclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Packet passed through middlewares, it may be modified in place
+*/
+type Packet = protocol.Message
+
+/*
+*
+Continue to the next middleware, or to the handler/socket after the last one
+*/
+type NextFunc func(ctx context.Context, p *Packet) error
+
+/*
+*
+Middleware runs around incoming event processing or outgoing emits, like
+socket.use() in Socket.IO. Returning an error without calling next drops the
+packet, the error is raised to the OnError handler
+*/
+type Middleware func(ctx context.Context, p *Packet, next NextFunc) error
+
+/*
+*
+Packet dropped by a middleware, Err is what the middleware returned
+*/
+type MiddlewareError struct {
+	Event    string
+	Incoming bool
+	Err      error
+}
+
+func (e *MiddlewareError) Error() string {
+	direction := "outgoing"
+	if e.Incoming {
+		direction = "incoming"
+	}
+	return fmt.Sprintf("%s middleware dropped event %q: %v", direction, e.Event, e.Err)
+}
+
+func (e *MiddlewareError) Unwrap() error {
+	return e.Err
+}
+
+type middlewares struct {
+	lock     sync.RWMutex
+	incoming []Middleware
+	outgoing []Middleware
+}
+
+/*
+*
+Build chain of given middlewares ending with final
+*/
+func chain(list []Middleware, final NextFunc) NextFunc {
+	next := final
+	for i := len(list) - 1; i >= 0; i-- {
+		mw, inner := list[i], next
+		next = func(ctx context.Context, p *Packet) error {
+			return mw(ctx, p, inner)
+		}
+	}
+	return next
+}
+
+/*
+*
+Run packet through chain ending with final, errors returned before final was
+reached are wrapped in MiddlewareError
+*/
+func runChain(ctx context.Context, list []Middleware, incoming bool, p *Packet, final NextFunc) error {
+	if len(list) == 0 {
+		return final(ctx, p)
+	}
+	reached := false
+	err := chain(list, func(ctx context.Context, p *Packet) error {
+		reached = true
+		return final(ctx, p)
+	})(ctx, p)
+	if err != nil && !reached {
+		return &MiddlewareError{Event: p.SocketEvent.EventName, Incoming: incoming, Err: err}
+	}
+	return err
+}
+
+func (mws *middlewares) runIncoming(ctx context.Context, p *Packet, final NextFunc) error {
+	mws.lock.RLock()
+	list := mws.incoming
+	mws.lock.RUnlock()
+	return runChain(ctx, list, true, p, final)
+}
+
+func (mws *middlewares) runOutgoing(ctx context.Context, p *Packet, final NextFunc) error {
+	mws.lock.RLock()
+	list := mws.outgoing
+	mws.lock.RUnlock()
+	return runChain(ctx, list, false, p, final)
+}
+
+/*
+*
+Add middleware run for every incoming event, in registration order
+*/
+func (c *Client) UseIncoming(mw Middleware) {
+	c.middlewares.lock.Lock()
+	c.middlewares.incoming = append(c.middlewares.incoming, mw)
+	c.middlewares.lock.Unlock()
+}
+
+/*
+*
+Add middleware run for every emit, in registration order
+*/
+func (c *Client) UseOutgoing(mw Middleware) {
+	c.middlewares.lock.Lock()
+	c.middlewares.outgoing = append(c.middlewares.outgoing, mw)
+	c.middlewares.lock.Unlock()
+}
@@ -0,0 +1,83 @@
+package socketioclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIncomingMiddlewareOrder(t *testing.T) {
+	c := newTestClient(ConConf{})
+	var calls []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		c.UseIncoming(func(ctx context.Context, p *Packet, next NextFunc) error {
+			calls = append(calls, name)
+			return next(ctx, p)
+		})
+	}
+	c.UseIncoming(func(ctx context.Context, p *Packet, next NextFunc) error {
+		p.SocketEvent.EventName = "renamed"
+		return next(ctx, p)
+	})
+	c.On("renamed", func(c *Channel) {
+		calls = append(calls, "handler")
+	})
+
+	receive(c, `42["say","hi"]`)
+
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestIncomingMiddlewareDrop(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.UseIncoming(func(ctx context.Context, p *Packet, next NextFunc) error {
+		return errors.New("denied")
+	})
+	called := false
+	c.On("say", func(c *Channel) { called = true })
+	var dropped *MiddlewareError
+	c.On(OnError, func(c *Channel, err error) { errors.As(err, &dropped) })
+
+	receive(c, `42["say","hi"]`)
+
+	if called {
+		t.Fatal("handler called for dropped packet")
+	}
+	if dropped == nil || !dropped.Incoming || dropped.Event != "say" {
+		t.Fatalf("error = %+v", dropped)
+	}
+}
+
+func TestOutgoingMiddleware(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+		p.SocketEvent.EventContent = "changed"
+		return next(ctx, p)
+	})
+
+	if err := c.TryEmit("say", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := queued(c), []string{`42/,0["say","changed"]`}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("queued = %v, want %v", got, want)
+	}
+}
+
+func TestSendErrorNotWrapped(t *testing.T) {
+	c := newTestClient(ConConf{})
+	c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+		return next(ctx, p)
+	})
+	c.out = make(chan frame)
+	c.outHigh = make(chan frame)
+
+	err := c.TryEmit("say", "hi")
+	var dropped *MiddlewareError
+	if err != ErrorSocketOverflood || errors.As(err, &dropped) {
+		t.Fatalf("TryEmit = %v, want unwrapped ErrorSocketOverflood", err)
+	}
+}
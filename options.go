@@ -0,0 +1,258 @@
+package socketioclient
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+	"github.com/liangsqrt/socketio-client-go/transport"
+)
+
+var (
+	ErrorUnsupportedScheme = errors.New("url scheme should be http, https, ws or wss")
+	ErrorMissingHost       = errors.New("url has no host")
+)
+
+type options struct {
+	conf      ConConf
+	transport transport.Transport
+}
+
+/*
+*
+Option configures a client created by New
+*/
+type Option func(o *options)
+
+/*
+*
+Use given transport instead of transport.GetDefaultWebsocketTransport()
+*/
+func WithTransport(tr transport.Transport) Option {
+	return func(o *options) { o.transport = tr }
+}
+
+/*
+*
+Send auth payload with the namespace CONNECT packet, like the auth option of the JS client
+*/
+func WithAuth(auth interface{}) Option {
+	return func(o *options) { o.conf.Auth = auth }
+}
+
+/*
+*
+Reconnect automatically, waiting delay times the attempt number between at most max attempts
+*/
+func WithReconnect(delay time.Duration, max int) Option {
+	return func(o *options) {
+		o.conf.AutoReconnect = true
+		o.conf.ReconnectDelay = delay
+		o.conf.ReconnectMax = max
+	}
+}
+
+/*
+*
+Server path, "/socket.io/" by default, like the path option of the JS client
+*/
+func WithPath(path string) Option {
+	return func(o *options) { o.conf.Path = path }
+}
+
+/*
+*
+Engine.IO protocol version, EngineIOv3 for Socket.IO v2 servers
+*/
+func WithProtocolVersion(version int) Option {
+	return func(o *options) { o.conf.ProtocolVersion = version }
+}
+
+/*
+*
+Add query parameters to those parsed from the url
+*/
+func WithQuery(query map[string]string) Option {
+	return func(o *options) {
+		for key, value := range query {
+			o.conf.Query[key] = value
+		}
+	}
+}
+
+/*
+*
+Report connection and message flow metrics to metrics, see the metrics/prometheus package
+*/
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) { o.conf.Metrics = metrics }
+}
+
+/*
+*
+Trace emits and handled events with tracer, see the tracing package
+*/
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) { o.conf.Tracer = tracer }
+}
+
+/*
+*
+How incoming packets reach the handlers: mode, number of workers, capacity
+of each worker queue and what to do when a queue is full. Zero workers and
+queueSize keep the defaults
+*/
+func WithDispatch(mode DispatchMode, workers int, queueSize int, queueFull QueueFullPolicy) Option {
+	return func(o *options) {
+		o.conf.DispatchMode = mode
+		o.conf.DispatchWorkers = workers
+		o.conf.DispatchQueueSize = queueSize
+		o.conf.DispatchQueueFull = queueFull
+	}
+}
+
+/*
+*
+Socket.IO packet encoding, protocol.MsgpackParser{} for servers using
+socket.io-msgpack-parser
+*/
+func WithParser(parser protocol.Parser) Option {
+	return func(o *options) { o.conf.Parser = parser }
+}
+
+/*
+*
+Maximum queued packets written with one flush, 1 disables batching
+*/
+func WithWriteBatch(packets int) Option {
+	return func(o *options) { o.conf.WriteBatch = packets }
+}
+
+/*
+*
+Outbound queue capacity and high-water mark, see ConConf.OnQueueHighWater
+*/
+func WithQueue(size int, highWater int, onHighWater func(depth int, high bool)) Option {
+	return func(o *options) {
+		o.conf.QueueSize = size
+		o.conf.QueueHighWater = highWater
+		o.conf.OnQueueHighWater = onHighWater
+	}
+}
+
+/*
+*
+Limit emits of the client to rate per second with bursts of burst emits
+*/
+func WithRateLimit(rate float64, burst int, mode RateLimitMode) Option {
+	return func(o *options) {
+		o.conf.RateLimit = RateLimit{Rate: rate, Burst: burst}
+		o.conf.RateLimitMode = mode
+	}
+}
+
+/*
+*
+Limit emits of one event, on top of the client limit. The mode is the one
+of WithRateLimit, RateLimitDelay by default
+*/
+func WithEventRateLimit(event string, rate float64, burst int) Option {
+	return func(o *options) {
+		if o.conf.EventRateLimits == nil {
+			o.conf.EventRateLimits = make(map[string]RateLimit)
+		}
+		o.conf.EventRateLimits[event] = RateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+/*
+*
+Send given events through the high priority lane, see Client.HighPriority
+*/
+func WithHighPriorityEvents(events ...string) Option {
+	return func(o *options) {
+		o.conf.HighPriorityEvents = append(o.conf.HighPriorityEvents, events...)
+	}
+}
+
+/*
+*
+Call f with errors returned by handlers and with recovered panics, instead
+of logging them
+*/
+func WithHandlerErrorHandler(f func(info EventInfo, err error)) Option {
+	return func(o *options) { o.conf.OnHandlerError = f }
+}
+
+/*
+*
+Create client and connect it, like io(url) of the JS client.
+
+The url scheme is http, https, ws or wss, the port defaults to 80 or 443,
+the path is the namespace and the query is sent with every request:
+
+	socketioclient.New("https://host:8443/admin?token=x", socketioclient.WithReconnect(time.Second, 10))
+*/
+func New(rawURL string, opts ...Option) (*Client, error) {
+	return NewContext(context.Background(), rawURL, opts...)
+}
+
+/*
+*
+New, giving up when ctx is done before the client is connected
+*/
+func NewContext(ctx context.Context, rawURL string, opts ...Option) (*Client, error) {
+	conf, namespace, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	o := &options{conf: conf}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.transport == nil {
+		o.transport = transport.GetDefaultWebsocketTransport()
+	}
+	return DialContext(ctx, o.conf, o.transport, &Namespace{Namespace: namespace})
+}
+
+/*
+*
+Split url into connection config and namespace, see New
+*/
+func ParseURL(rawURL string) (ConConf, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ConConf{}, "", err
+	}
+
+	conf := ConConf{Host: u.Hostname(), Query: map[string]string{}}
+	if conf.Host == "" {
+		return ConConf{}, "", ErrorMissingHost
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		conf.Secure = true
+		conf.Port = 443
+	case "http", "ws":
+		conf.Port = 80
+	default:
+		return ConConf{}, "", ErrorUnsupportedScheme
+	}
+	if port := u.Port(); port != "" {
+		if conf.Port, err = strconv.Atoi(port); err != nil {
+			return ConConf{}, "", err
+		}
+	}
+	for key, values := range u.Query() {
+		if len(values) > 0 {
+			conf.Query[key] = values[0]
+		}
+	}
+
+	return conf, strings.Trim(u.Path, "/"), nil
+}
@@ -0,0 +1,106 @@
+package socketioclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/transport"
+)
+
+func TestAuthPerClient(t *testing.T) {
+	server := newTestServer(t)
+	tr := transport.GetDefaultWebsocketTransport()
+
+	for _, token := range []string{"a", "b"} {
+		c, err := New(server.URL+"/chat", WithTransport(tr), WithAuth(map[string]string{"token": token}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+
+	posts := server.postBodies()
+	want := []string{`40/chat,{"token":"a"}`, `40/chat,{"token":"b"}`}
+	if len(posts) != 2 || posts[0] != want[0] || posts[1] != want[1] {
+		t.Fatalf("CONNECT packets = %q, want %q", posts, want)
+	}
+	if tr.Auth != nil || tr.Metrics != nil || tr.Parser != nil {
+		t.Fatal("client settings written to the shared transport")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		conf      ConConf
+		namespace string
+		err       error
+	}{
+		{"http://example.com", ConConf{Host: "example.com", Port: 80}, "", nil},
+		{"https://example.com/", ConConf{Host: "example.com", Port: 443, Secure: true}, "", nil},
+		{"ws://example.com:8080/chat", ConConf{Host: "example.com", Port: 8080}, "chat", nil},
+		{"wss://example.com/admin/?token=x", ConConf{Host: "example.com", Port: 443, Secure: true, Query: map[string]string{"token": "x"}}, "admin", nil},
+		{"http://[::1]:3000", ConConf{Host: "::1", Port: 3000}, "", nil},
+		{"ftp://example.com", ConConf{}, "", ErrorUnsupportedScheme},
+		{"http://", ConConf{}, "", ErrorMissingHost},
+		{"http:///chat", ConConf{}, "", ErrorMissingHost},
+		{"example.com/chat", ConConf{}, "", ErrorMissingHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			conf, namespace, err := ParseURL(tt.url)
+			if err != tt.err {
+				t.Fatalf("ParseURL error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if conf.Host != tt.conf.Host || conf.Port != tt.conf.Port || conf.Secure != tt.conf.Secure || namespace != tt.namespace {
+				t.Fatalf("ParseURL = %+v %q, want %+v %q", conf, namespace, tt.conf, tt.namespace)
+			}
+			if len(conf.Query) != len(tt.conf.Query) || conf.Query["token"] != tt.conf.Query["token"] {
+				t.Fatalf("query = %v, want %v", conf.Query, tt.conf.Query)
+			}
+		})
+	}
+}
+
+func TestNewMissingHost(t *testing.T) {
+	if _, err := New("http:///chat"); err != ErrorMissingHost {
+		t.Fatalf("New = %v, want ErrorMissingHost", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	o := &options{conf: ConConf{Query: map[string]string{"a": "1"}}}
+	onError := func(EventInfo, error) {}
+	for _, opt := range []Option{
+		WithPath("/custom/"),
+		WithProtocolVersion(EngineIOv3),
+		WithQuery(map[string]string{"b": "2"}),
+		WithReconnect(time.Second, 3),
+		WithDispatch(DispatchPool, 4, 16, QueueFullDrop),
+		WithHandlerErrorHandler(onError),
+		WithQueue(100, 80, nil),
+		WithHighPriorityEvents("a", "b"),
+		WithEventRateLimit("a", 10, 2),
+	} {
+		opt(o)
+	}
+	conf := o.conf
+	if conf.Path != "/custom/" || conf.ProtocolVersion != EngineIOv3 || conf.Query["a"] != "1" || conf.Query["b"] != "2" {
+		t.Fatalf("conf = %+v", conf)
+	}
+	if !conf.AutoReconnect || conf.ReconnectDelay != time.Second || conf.ReconnectMax != 3 {
+		t.Fatalf("reconnect = %v %v %v", conf.AutoReconnect, conf.ReconnectDelay, conf.ReconnectMax)
+	}
+	if conf.DispatchMode != DispatchPool || conf.DispatchWorkers != 4 || conf.DispatchQueueSize != 16 || conf.DispatchQueueFull != QueueFullDrop {
+		t.Fatalf("dispatch = %+v", conf)
+	}
+	if conf.OnHandlerError == nil || conf.QueueSize != 100 || conf.QueueHighWater != 80 {
+		t.Fatalf("conf = %+v", conf)
+	}
+	if len(conf.HighPriorityEvents) != 2 || conf.EventRateLimits["a"] != (RateLimit{Rate: 10, Burst: 2}) {
+		t.Fatalf("conf = %+v", conf)
+	}
+}
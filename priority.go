@@ -0,0 +1,46 @@
+package socketioclient
+
+import (
+	"context"
+)
+
+/*
+*
+Emitter of packets sent through the high priority lane, ahead of everything
+queued by Emit. Packets keep their order within a lane, so high priority
+events may overtake earlier regular ones but never each other
+*/
+type Priority struct {
+	client *Client
+}
+
+/*
+*
+High priority emitter of the client, for control events that must not wait
+behind bulk traffic
+
+	c.HighPriority().Emit("auth_refresh", token)
+
+Events of ConConf.HighPriorityEvents take this lane with a plain Emit
+*/
+func (c *Client) HighPriority() *Priority {
+	return &Priority{client: c}
+}
+
+/*
+*
+Emit without waiting, fails with ErrorSocketOverflood when the lane is full
+like Client.Emit
+*/
+func (p *Priority) Emit(method string, args interface{}) error {
+	return p.client.emit(context.Background(), method, args, 0, queueTry, true)
+}
+
+/*
+*
+Emit with ctx as parent of the emit span, waits for room in the lane like
+Client.EmitContext
+*/
+func (p *Priority) EmitContext(ctx context.Context, method string, args interface{}) error {
+	return p.client.emit(ctx, method, args, 0, queueBlock, true)
+}
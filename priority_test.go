@@ -0,0 +1,108 @@
+package socketioclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+func TestHighPriorityOvertakesBulk(t *testing.T) {
+	c := newTestClient(ConConf{HighPriorityEvents: []string{"auth"}})
+	conn := newFakeConn()
+	c.conn = conn
+	for _, event := range []string{"a", "b"} {
+		if err := c.Emit(event, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.HighPriority().Emit("urgent", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("auth", 1); err != nil {
+		t.Fatal(err)
+	}
+	go outLoop(&c.Channel, &c.methods)
+	defer c.setAliveValue(false)
+
+	want := []string{`42/,0["urgent",1]`, `42/,0["auth",1]`, `42/,0["a",1]`, `42/,0["b",1]`}
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn.lock.Lock()
+		written := append([]string(nil), conn.written...)
+		conn.lock.Unlock()
+		if len(written) == len(want) {
+			if !reflect.DeepEqual(written, want) {
+				t.Fatalf("written %v, want %v", written, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("written %v, want %v", written, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHighPriorityEmitFailsFastOnFullLane(t *testing.T) {
+	c := newTestClient(ConConf{QueueSize: 1})
+	if err := c.HighPriority().Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HighPriority().Emit("event", 2); err != ErrorSocketOverflood {
+		t.Fatalf("Emit on a full lane = %v, want ErrorSocketOverflood", err)
+	}
+	// the bulk lane is separate
+	if err := c.Emit("event", 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPingerWaitsForRoomInHighLane(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{ProtocolVersion: EngineIOv3, QueueSize: 1, Metrics: metrics})
+	c.conn = newFakeConn()
+	c.connParams().OnOpen(`{"sid":"abc","pingInterval":5,"pingTimeout":5000}`)
+	c.startSession()
+	defer c.endSession()
+	if err := c.HighPriority().Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	go pinger(&c.Channel)
+	defer c.setAliveValue(false)
+
+	// several intervals pass while the lane is full
+	time.Sleep(30 * time.Millisecond)
+	if n := metrics.counter(MetricControlDropped); n != 0 {
+		t.Fatalf("control dropped = %v, the pinger skipped a heartbeat", n)
+	}
+	(<-c.outHigh).release()
+	select {
+	case f := <-c.outHigh:
+		if string(f.data) != protocol.PongMessage {
+			t.Fatalf("pinger sent %q", f.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no ping after the lane had room")
+	}
+}
+
+func TestVolatileThresholdCountsBothLanes(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{QueueSize: 4, VolatileThreshold: 1, Metrics: metrics})
+	for i := 0; i < 2; i++ {
+		if err := c.HighPriority().Emit("event", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Volatile().Emit("cursor", 1); err != nil {
+		t.Fatalf("dropped volatile emit returned %v", err)
+	}
+	if n := metrics.counter(MetricVolatileDropped); n != 1 {
+		t.Fatalf("volatile dropped = %v with the high lane above the threshold, want 1", n)
+	}
+	if got := queued(c); len(got) != 2 {
+		t.Fatalf("queued %q, want the two high priority emits", got)
+	}
+}
@@ -0,0 +1,162 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"unicode/utf8"
+)
+
+/*
+JSON implementation used for packet payloads, e.g. a wrapper of
+github.com/goccy/go-json or github.com/json-iterator/go. Unmarshal must
+support json.RawMessage targets
+*/
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+/*
+Optional codec method appending the encoding of v to dst, saves the copy
+of Marshal result on the emit path
+*/
+type JSONAppender interface {
+	AppendMarshal(dst []byte, v interface{}) ([]byte, error)
+}
+
+/*
+encoding/json codec, the default
+*/
+type StdJSONCodec struct{}
+
+type jsonEncoder struct {
+	buf     bytes.Buffer
+	encoder *json.Encoder
+}
+
+var jsonEncoders = sync.Pool{New: func() interface{} {
+	e := &jsonEncoder{}
+	e.encoder = json.NewEncoder(&e.buf)
+	return e
+}}
+
+/*
+Encode with a pooled json.Encoder, unlike json.Marshal the result is not
+copied into a new slice
+*/
+func (StdJSONCodec) AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	e := jsonEncoders.Get().(*jsonEncoder)
+	defer func() {
+		if e.buf.Cap() <= maxPooledSize {
+			e.buf.Reset()
+			jsonEncoders.Put(e)
+		}
+	}()
+	if err := e.encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode terminates the value with a newline
+	return append(dst, e.buf.Bytes()[:e.buf.Len()-1]...), nil
+}
+
+func (StdJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (StdJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// larger buffers are left to the garbage collector
+const maxPooledSize = 64 * 1024
+
+var defaultCodec JSONCodec = StdJSONCodec{}
+
+/*
+Replace the codec used by Encode, GetSocketIoMessage and parsers without
+their own codec. Prefer the Codec field of the parser, this one is shared by
+every client of the process. Not safe to call while clients are running,
+set it at startup
+*/
+func SetJSONCodec(codec JSONCodec) {
+	if codec == nil {
+		codec = StdJSONCodec{}
+	}
+	defaultCodec = codec
+}
+
+func codecOrDefault(codec JSONCodec) JSONCodec {
+	if codec == nil {
+		return defaultCodec
+	}
+	return codec
+}
+
+func appendJSON(dst []byte, codec JSONCodec, v interface{}) ([]byte, error) {
+	if appender, ok := codec.(JSONAppender); ok {
+		return appender.AppendMarshal(dst, v)
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+const hex = "0123456789abcdef"
+
+/*
+Append s as JSON string, escaped like encoding/json does
+*/
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -1,5 +1,7 @@
 package protocol
 
+import "encoding/json"
+
 /*
 https://github.com/socketio/socket.io-protocol#0---connect
 */
@@ -89,7 +91,14 @@ type SocketEventArray struct {
 	Emit []string
 }
 type SocketEvent struct {
-	NS           string
+	NS string
+	// packet id, set when the sender expects an ack
+	ID           int
+	HasID        bool
 	EventName    string
 	EventContent interface{}
+	// arguments following EventContent when encoding
+	ExtraArgs []interface{}
+	// every decoded argument after the event name, EventContent included
+	Args []json.RawMessage
 }
@@ -0,0 +1,419 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrorMsgpack = errors.New("invalid msgpack data")
+
+/*
+Parser compatible with socket.io-msgpack-parser: every socket.io packet is a
+MessagePack map {type, nsp, data, id} sent as a binary frame.
+
+Values are converted through their JSON representation with Codec, so json
+struct tags apply and handlers receive the same types as with JSONParser
+*/
+type MsgpackParser struct {
+	Codec JSONCodec
+}
+
+func (p MsgpackParser) Encode(msg *Message) ([]byte, bool, error) {
+	packet := map[string]interface{}{
+		"type": int64(msg.SocketType),
+		"nsp":  "/" + msg.SocketEvent.NS,
+	}
+	switch msg.SocketType {
+	case SocketMessageTypeConnect:
+		if msg.SocketEvent.EventContent != nil {
+			packet["data"] = msg.SocketEvent.EventContent
+		}
+	case SocketMessageTypeAck:
+		args := []interface{}{}
+		if msg.SocketEvent.EventContent != nil {
+			args = append(args, msg.SocketEvent.EventContent)
+		}
+		packet["data"] = append(args, msg.SocketEvent.ExtraArgs...)
+	case SocketMessageTypeEvent:
+		args := []interface{}{msg.SocketEvent.EventName}
+		if msg.SocketEvent.EventContent != "" {
+			args = append(args, msg.SocketEvent.EventContent)
+		}
+		packet["data"] = append(args, msg.SocketEvent.ExtraArgs...)
+	}
+	if msg.SocketEvent.HasID {
+		packet["id"] = int64(msg.SocketEvent.ID)
+	}
+
+	// go through JSON so struct tags and marshalers are honoured
+	raw, err := codecOrDefault(p.Codec).Marshal(packet)
+	if err != nil {
+		return nil, false, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, false, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, tree); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+func (p MsgpackParser) Decode(data []byte, isBinary bool) (*Message, error) {
+	if !isBinary {
+		return nil, ErrorWrongMessageType
+	}
+	r := &msgpackReader{data: data}
+	value, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	packet, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrorWrongPacket
+	}
+
+	packetType, ok := toInt(packet["type"])
+	if !ok {
+		return nil, ErrorWrongPacket
+	}
+	msg := &Message{
+		EngineIoType: EngineMessageTypeMessage,
+		SocketType:   SocketMessageType(packetType),
+	}
+	nsp, _ := packet["nsp"].(string)
+	msg.SocketEvent.NS = strings.TrimPrefix(nsp, "/")
+	if id, ok := toInt(packet["id"]); ok {
+		msg.SocketEvent.ID = id
+		msg.SocketEvent.HasID = true
+	}
+
+	args, _ := packet["data"].([]interface{})
+	if msg.SocketType == SocketMessageTypeEvent {
+		if len(args) == 0 {
+			return nil, ErrorWrongPacket
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, ErrorWrongPacket
+		}
+		msg.SocketEvent.EventName = name
+		args = args[1:]
+	}
+
+	// decode arguments the way GetSocketIoMessage does
+	codec := codecOrDefault(p.Codec)
+	msg.SocketEvent.Args = make([]json.RawMessage, 0, len(args))
+	for _, arg := range args {
+		raw, err := codec.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		msg.SocketEvent.Args = append(msg.SocketEvent.Args, raw)
+	}
+	if len(msg.SocketEvent.Args) > 0 {
+		if err := codec.Unmarshal(msg.SocketEvent.Args[0], &msg.SocketEvent.EventContent); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case float64:
+		return int(n), n == math.Trunc(n)
+	}
+	return 0, false
+}
+
+/*
+Write JSON data model value (as decoded with UseNumber) in MessagePack format
+*/
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int64:
+		writeMsgpackInt(buf, value)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, f)
+	case string:
+		writeMsgpackHeader(buf, len(value), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(value)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(value), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMsgpack(buf, key)
+			if err := writeMsgpack(buf, value[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+/*
+Write integer in its smallest format, unsigned formats for positive values
+like notepack.io, the encoder of socket.io-msgpack-parser
+*/
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i > 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i > 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i > 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i > 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	case i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+/*
+Write length header: fix format under fixMax, then 8 (if any), 16 and 32 bit formats
+*/
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// nesting of arrays and maps accepted by the reader
+const msgpackMaxDepth = 100
+
+type msgpackReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, ErrorMsgpack
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+/*
+Read one value: maps decode to map[string]interface{}, integers to int64 or
+uint64, floats to float64, bin to []byte. Extension types are not supported
+*/
+func (r *msgpackReader) read() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return r.readMap(int(code & 0x0f))
+	case code&0xf0 == 0x90:
+		return r.readArray(int(code & 0x0f))
+	case code&0xe0 == 0xa0:
+		return r.readString(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	case 0xca:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (code - 0xcc))
+	case 0xd0:
+		n, err := r.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := r.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := r.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := r.uint(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.readString(int(n))
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readArray(int(n))
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMap(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", code)
+}
+
+func (r *msgpackReader) readString(n int) (string, error) {
+	b, err := r.next(n)
+	return string(b), err
+}
+
+func (r *msgpackReader) readArray(n int) ([]interface{}, error) {
+	// every item takes at least one byte
+	if n > len(r.data)-r.pos || r.depth >= msgpackMaxDepth {
+		return nil, ErrorMsgpack
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func (r *msgpackReader) readMap(n int) (map[string]interface{}, error) {
+	if n > (len(r.data)-r.pos)/2 || r.depth >= msgpackMaxDepth {
+		return nil, ErrorMsgpack
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, nil
+}
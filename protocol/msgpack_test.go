@@ -0,0 +1,276 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+/*
+*
+map16 of n entries "k00": 0, "k01": 0... with the keys sorted, and its decoded value
+*/
+func msgpackMap16(n int) ([]byte, map[string]interface{}, map[string]interface{}) {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(100 + i)[1:]
+	}
+	sort.Strings(keys)
+	data := []byte{0xde, byte(n >> 8), byte(n)}
+	decoded := map[string]interface{}{}
+	in := map[string]interface{}{}
+	for _, key := range keys {
+		data = append(data, 0xa0|byte(len(key)))
+		data = append(data, key...)
+		data = append(data, 0)
+		decoded[key] = int64(0)
+		in[key] = int64(0)
+	}
+	return data, decoded, in
+}
+
+func TestMsgpackFormats(t *testing.T) {
+	str8, str16, str32 := strings.Repeat("a", 32), strings.Repeat("b", 256), strings.Repeat("c", 65536)
+	array16 := make([]interface{}, 16)
+	for i := range array16 {
+		array16[i] = int64(0)
+	}
+	map16, map16Decoded, map16In := msgpackMap16(16)
+
+	tests := []struct {
+		name string
+		data []byte
+		// value read from data
+		want interface{}
+		// value written as data, nil when the writer never produces the format
+		in interface{}
+	}{
+		{"nil", []byte{0xc0}, nil, nil},
+		{"false", []byte{0xc2}, false, false},
+		{"true", []byte{0xc3}, true, true},
+		{"positive fixint", []byte{0x05}, int64(5), int64(5)},
+		{"positive fixint max", []byte{0x7f}, int64(127), int64(127)},
+		{"negative fixint", []byte{0xff}, int64(-1), int64(-1)},
+		{"negative fixint min", []byte{0xe0}, int64(-32), int64(-32)},
+		{"int8", []byte{0xd0, 0xdf}, int64(-33), int64(-33)},
+		{"int8 min", []byte{0xd0, 0x80}, int64(-128), int64(-128)},
+		{"int16", []byte{0xd1, 0xff, 0x7f}, int64(-129), int64(-129)},
+		{"int32", []byte{0xd2, 0xff, 0xff, 0x7f, 0xff}, int64(-32769), int64(-32769)},
+		{"int64", []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xff, 0xff}, int64(-2147483649), int64(-2147483649)},
+		{"uint8", []byte{0xcc, 0xc8}, uint64(200), int64(200)},
+		{"uint16", []byte{0xcd, 0x01, 0x00}, uint64(256), int64(256)},
+		{"uint32", []byte{0xce, 0x00, 0x01, 0x00, 0x00}, uint64(65536), int64(65536)},
+		{"uint64", []byte{0xcf, 0, 0, 0, 1, 0, 0, 0, 0}, uint64(4294967296), int64(4294967296)},
+		{"float32", []byte{0xca, 0x3f, 0xc0, 0, 0}, 1.5, nil},
+		{"float64", []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5, json.Number("1.5")},
+		{"json integer", []byte{0xcd, 0x01, 0x2c}, uint64(300), json.Number("300")},
+		{"fixstr", []byte{0xa3, 'a', 'b', 'c'}, "abc", "abc"},
+		{"str8", cat([]byte{0xd9, 32}, []byte(str8)), str8, str8},
+		{"str16", cat([]byte{0xda, 0x01, 0x00}, []byte(str16)), str16, str16},
+		{"str32", cat([]byte{0xdb, 0x00, 0x01, 0x00, 0x00}, []byte(str32)), str32, str32},
+		{"bin8", []byte{0xc4, 2, 1, 2}, []byte{1, 2}, nil},
+		{"bin16", []byte{0xc5, 0, 1, 9}, []byte{9}, nil},
+		{"bin32", []byte{0xc6, 0, 0, 0, 1, 9}, []byte{9}, nil},
+		{"fixarray", []byte{0x92, 0x01, 0xa1, 'a'}, []interface{}{int64(1), "a"}, []interface{}{int64(1), "a"}},
+		{"array16", cat([]byte{0xdc, 0, 16}, make([]byte, 16)), array16, array16},
+		{"array32", []byte{0xdd, 0, 0, 0, 2, 1, 2}, []interface{}{int64(1), int64(2)}, nil},
+		{"fixmap", []byte{0x81, 0xa1, 'a', 0x01}, map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(1)}},
+		{"map16", map16, map16Decoded, map16In},
+		{"map32", []byte{0xdf, 0, 0, 0, 1, 0xa1, 'a', 0x01}, map[string]interface{}{"a": int64(1)}, nil},
+		{"integer map key", []byte{0x81, 0x07, 0x01}, map[string]interface{}{"7": int64(1)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &msgpackReader{data: tt.data}
+			got, err := r.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("read = %#v, want %#v", got, tt.want)
+			}
+			if r.pos != len(tt.data) {
+				t.Fatalf("read %d of %d bytes", r.pos, len(tt.data))
+			}
+			if tt.in == nil && tt.name != "nil" {
+				return
+			}
+			var buf bytes.Buffer
+			if err := writeMsgpack(&buf, tt.in); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.data) {
+				t.Fatalf("write = % x, want % x", buf.Bytes(), tt.data)
+			}
+		})
+	}
+}
+
+/*
+*
+Packets as written by notepack.io, the encoder of socket.io-msgpack-parser,
+with the keys in the order the socket.io server sets them
+*/
+var msgpackFixtures = []struct {
+	name string
+	data []byte
+	want Message
+	args []string
+}{
+	{
+		// socket.emit("hello", 1)
+		name: "event",
+		data: cat([]byte{0x83, 0xa4}, []byte("type"), []byte{0x02, 0xa4}, []byte("data"),
+			[]byte{0x92, 0xa5}, []byte("hello"), []byte{0x01, 0xa3}, []byte("nsp"), []byte{0xa1, '/'}),
+		want: Message{SocketType: SocketMessageTypeEvent, SocketEvent: SocketEvent{EventName: "hello"}},
+		args: []string{"1"},
+	},
+	{
+		// io.of("/chat").emitWithAck("ask", "é", 200)
+		name: "event with ack",
+		data: cat([]byte{0x84, 0xa4}, []byte("type"), []byte{0x02, 0xa4}, []byte("data"),
+			[]byte{0x93, 0xa3}, []byte("ask"), []byte{0xa2, 0xc3, 0xa9, 0xcc, 0xc8},
+			[]byte{0xa3}, []byte("nsp"), []byte{0xa5}, []byte("/chat"), []byte{0xa2}, []byte("id"), []byte{0x0c}),
+		want: Message{SocketType: SocketMessageTypeEvent, SocketEvent: SocketEvent{EventName: "ask", NS: "chat", ID: 12, HasID: true}},
+		args: []string{`"é"`, "200"},
+	},
+	{
+		// CONNECT answer of the server
+		name: "connect",
+		data: cat([]byte{0x83, 0xa4}, []byte("type"), []byte{0x00, 0xa4}, []byte("data"),
+			[]byte{0x81, 0xa3}, []byte("sid"), []byte{0xa3}, []byte("abc"), []byte{0xa3}, []byte("nsp"), []byte{0xa1, '/'}),
+		want: Message{SocketType: SocketMessageTypeConnect},
+	},
+	{
+		// ack callback({x: 1.5, n: null}) of packet 300
+		name: "ack",
+		data: cat([]byte{0x84, 0xa4}, []byte("type"), []byte{0x03, 0xa4}, []byte("data"),
+			[]byte{0x91, 0x82, 0xa1, 'x', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0xa1, 'n', 0xc0},
+			[]byte{0xa3}, []byte("nsp"), []byte{0xa1, '/'}, []byte{0xa2}, []byte("id"), []byte{0xcd, 0x01, 0x2c}),
+		want: Message{SocketType: SocketMessageTypeAck, SocketEvent: SocketEvent{ID: 300, HasID: true}},
+		args: []string{`{"n":null,"x":1.5}`},
+	},
+}
+
+func TestMsgpackDecodeFixtures(t *testing.T) {
+	for _, tt := range msgpackFixtures {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := MsgpackParser{}.Decode(tt.data, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.SocketType != tt.want.SocketType || msg.SocketEvent.NS != tt.want.SocketEvent.NS ||
+				msg.SocketEvent.EventName != tt.want.SocketEvent.EventName ||
+				msg.SocketEvent.ID != tt.want.SocketEvent.ID || msg.SocketEvent.HasID != tt.want.SocketEvent.HasID {
+				t.Fatalf("decoded %+v, want %+v", msg, tt.want)
+			}
+			if len(msg.SocketEvent.Args) != len(tt.args) {
+				t.Fatalf("args = %s, want %s", msg.SocketEvent.Args, tt.args)
+			}
+			for i, arg := range msg.SocketEvent.Args {
+				if string(arg) != tt.args[i] {
+					t.Fatalf("arg %d = %s, want %s", i, arg, tt.args[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMsgpackEncode(t *testing.T) {
+	msg := &Message{SocketType: SocketMessageTypeEvent}
+	msg.SocketEvent.EventName = "hello"
+	msg.SocketEvent.EventContent = 200
+	msg.SocketEvent.ID = 300
+	msg.SocketEvent.HasID = true
+
+	data, binary, err := MsgpackParser{}.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !binary {
+		t.Fatal("msgpack packet not binary")
+	}
+	// values as notepack.io writes them, keys sorted
+	want := cat([]byte{0x84, 0xa4}, []byte("data"), []byte{0x92, 0xa5}, []byte("hello"), []byte{0xcc, 0xc8},
+		[]byte{0xa2}, []byte("id"), []byte{0xcd, 0x01, 0x2c}, []byte{0xa3}, []byte("nsp"), []byte{0xa1, '/'},
+		[]byte{0xa4}, []byte("type"), []byte{0x02})
+	if !bytes.Equal(data, want) {
+		t.Fatalf("encoded % x, want % x", data, want)
+	}
+
+	decoded, err := MsgpackParser{}.Decode(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SocketEvent.EventName != "hello" || decoded.SocketEvent.ID != 300 || string(decoded.SocketEvent.Args[0]) != "200" {
+		t.Fatalf("round trip = %+v", decoded)
+	}
+}
+
+func TestMsgpackTruncated(t *testing.T) {
+	for _, tt := range msgpackFixtures {
+		for n := 0; n < len(tt.data); n++ {
+			if _, err := (MsgpackParser{}).Decode(tt.data[:n], true); err == nil {
+				t.Fatalf("%s truncated to %d bytes decoded", tt.name, n)
+			}
+		}
+	}
+}
+
+func TestMsgpackHostile(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"array32 count beyond data", []byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"array16 count beyond data", []byte{0xdc, 0x00, 0x03, 0x01, 0x02}},
+		{"map32 count beyond data", []byte{0xdf, 0xff, 0xff, 0xff, 0xff, 0xa1, 'a', 0x01}},
+		{"map entry without value", []byte{0x81, 0xa1, 'a'}},
+		{"str32 length beyond data", []byte{0xdb, 0xff, 0xff, 0xff, 0xff, 'a'}},
+		{"bin32 length beyond data", []byte{0xc6, 0xff, 0xff, 0xff, 0xff, 0x00}},
+		{"float64 truncated", []byte{0xcb, 0x3f, 0xf8}},
+		{"uint64 truncated", []byte{0xcf, 0x00}},
+		{"nesting too deep", append(bytes.Repeat([]byte{0x91}, 10000), 0x00)},
+		{"never used format", []byte{0xc1}},
+		{"extension", []byte{0xd4, 0x01, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &msgpackReader{data: tt.data}
+			if v, err := r.read(); err == nil {
+				t.Fatalf("read %#v", v)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeInvalidPacket(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		binary bool
+		want   error
+	}{
+		{"text frame", []byte(`42["hello"]`), false, ErrorWrongMessageType},
+		{"not a map", []byte{0x92, 0x01, 0x02}, true, ErrorWrongPacket},
+		{"missing type", []byte{0x81, 0xa3, 'n', 's', 'p', 0xa1, '/'}, true, ErrorWrongPacket},
+		{"event without data", []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0x02}, true, ErrorWrongPacket},
+		{"event name not a string", cat([]byte{0x82, 0xa4}, []byte("type"), []byte{0x02, 0xa4}, []byte("data"), []byte{0x91, 0x01}), true, ErrorWrongPacket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := (MsgpackParser{}).Decode(tt.data, tt.binary); err != tt.want {
+				t.Fatalf("Decode = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
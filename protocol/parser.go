@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"strconv"
+)
+
+/*
+Parser encodes and decodes socket.io packets, like the parser option of the
+JS client. Engine.IO packets (ping, pong...) are always text and do not go
+through the parser.
+
+Encode tells whether data must be sent as a binary frame. Decode receives
+text frames with their Engine.IO type prefix, e.g. 42["event"], and binary
+frames as they are
+*/
+type Parser interface {
+	Encode(msg *Message) (data []byte, binary bool, err error)
+	Decode(data []byte, binary bool) (*Message, error)
+}
+
+/*
+Parser able to encode into a caller provided buffer, e.g. a pooled one
+*/
+type AppendEncoder interface {
+	AppendEncode(dst []byte, msg *Message) (data []byte, binary bool, err error)
+}
+
+/*
+Default socket.io parser, packets are text frames. Codec defaults to the
+one set by SetJSONCodec
+*/
+type JSONParser struct {
+	Codec JSONCodec
+}
+
+func (p JSONParser) Encode(msg *Message) ([]byte, bool, error) {
+	return p.AppendEncode(nil, msg)
+}
+
+func (p JSONParser) AppendEncode(dst []byte, msg *Message) ([]byte, bool, error) {
+	data, err := AppendEncode(dst, msg, p.Codec)
+	return data, false, err
+}
+
+func (p JSONParser) Decode(data []byte, binary bool) (*Message, error) {
+	if binary {
+		return nil, ErrorWrongMessageType
+	}
+	pkg := string(data)
+	socketType, err := GetSocketMessageType(pkg)
+	if err != nil {
+		return nil, err
+	}
+	if socketType == SocketMessageTypeEvent {
+		return getSocketIoMessage(pkg, codecOrDefault(p.Codec))
+	}
+	if socketType == SocketMessageTypeAck {
+		return getSocketIoAck(pkg, codecOrDefault(p.Codec))
+	}
+	return &Message{EngineIoType: EngineMessageTypeMessage, SocketType: socketType}, nil
+}
+
+/*
+Append CONNECT packet of namespace with optional auth payload after the
+Engine.IO type, the root namespace is implicit
+*/
+func appendConnect(dst []byte, msg *Message, codec JSONCodec) ([]byte, error) {
+	dst = strconv.AppendInt(dst, int64(msg.SocketType), 10)
+	if msg.SocketEvent.NS != "" {
+		dst = append(dst, '/')
+		dst = append(dst, msg.SocketEvent.NS...)
+		dst = append(dst, ',')
+	}
+	if msg.SocketEvent.EventContent != nil {
+		return appendJSON(dst, codec, msg.SocketEvent.EventContent)
+	}
+	return dst, nil
+}
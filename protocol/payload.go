@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Engine.IO v3 polling payload: every packet is prefixed by its length in
+UTF-16 code units and a colon, e.g. 2:403:4ab
+*/
+func EncodePayloadV3(packets ...string) string {
+	var b strings.Builder
+	for _, packet := range packets {
+		b.WriteString(strconv.Itoa(utf16Len(packet)))
+		b.WriteByte(':')
+		b.WriteString(packet)
+	}
+	return b.String()
+}
+
+/*
+Split Engine.IO v3 polling payload into packets
+*/
+func DecodePayloadV3(payload string) ([]string, error) {
+	var packets []string
+	for len(payload) > 0 {
+		colon := strings.IndexByte(payload, ':')
+		if colon <= 0 {
+			return nil, ErrorWrongPacket
+		}
+		length, err := strconv.Atoi(payload[:colon])
+		if err != nil || length < 0 {
+			return nil, ErrorWrongPacket
+		}
+		payload = payload[colon+1:]
+
+		// walk the string until length UTF-16 code units were consumed
+		end, units := 0, 0
+		for units < length {
+			if end >= len(payload) {
+				return nil, ErrorWrongPacket
+			}
+			r, size := utf8.DecodeRuneInString(payload[end:])
+			units += runeUnits(r)
+			end += size
+		}
+		// length ends inside a surrogate pair
+		if units > length {
+			return nil, ErrorWrongPacket
+		}
+		packets = append(packets, payload[:end])
+		payload = payload[end:]
+	}
+	return packets, nil
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeUnits(r)
+	}
+	return n
+}
+
+/*
+UTF-16 code units of r, runes outside the basic plane take a surrogate pair
+*/
+func runeUnits(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
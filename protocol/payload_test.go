@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodePayloadV3(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets []string
+		want    string
+	}{
+		{"none", nil, ""},
+		{"one", []string{"40"}, "2:40"},
+		{"several", []string{"40", "42/chat,[\"a\"]", "2"}, "2:4013:42/chat,[\"a\"]1:2"},
+		{"empty packet", []string{""}, "0:"},
+		{"two byte characters", []string{`42["é"]`}, "7:42[\"é\"]"},
+		{"three byte characters", []string{`42["日本"]`}, "8:42[\"日本\"]"},
+		{"surrogate pair", []string{`42["😀"]`}, "8:42[\"😀\"]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EncodePayloadV3(tt.packets...); got != tt.want {
+				t.Fatalf("EncodePayloadV3() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadV3(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+		err     bool
+	}{
+		{"empty", "", nil, false},
+		{"one", "2:40", []string{"40"}, false},
+		{"open and connect", `79:0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}2:40`,
+			[]string{`0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`, "40"}, false},
+		{"several", "2:4013:42/chat,[\"a\"]1:2", []string{"40", "42/chat,[\"a\"]", "2"}, false},
+		{"empty packet", "0:1:6", []string{"", "6"}, false},
+		{"colon in packet", `9:42["a:b"]`, []string{`42["a:b"]`}, false},
+		{"length in characters", "7:42[\"é\"]2:40", []string{"42[\"é\"]", "40"}, false},
+		{"surrogate pair counts twice", "8:42[\"😀\"]1:3", []string{"42[\"😀\"]", "3"}, false},
+		{"missing colon", "240", nil, true},
+		{"missing length", ":40", nil, true},
+		{"length not a number", "a:40", nil, true},
+		{"negative length", "-1:40", nil, true},
+		{"length too long", "3:40", nil, true},
+		{"truncated second packet", "2:405:42", nil, true},
+		{"length ends inside a surrogate pair", "6:42[\"😀\"]", nil, true},
+		{"trailing garbage", "2:40x", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePayloadV3(tt.payload)
+			if tt.err {
+				if err != ErrorWrongPacket {
+					t.Fatalf("DecodePayloadV3(%q) = %q, %v, want ErrorWrongPacket", tt.payload, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DecodePayloadV3(%q) = %q, want %q", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPayloadV3RoundTrip(t *testing.T) {
+	packets := []string{"0{}", "40", `42["日本","😀","a:b"]`, "", "6"}
+	got, err := DecodePayloadV3(EncodePayloadV3(packets...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, packets) {
+		t.Fatalf("round trip = %q, want %q", got, packets)
+	}
+}
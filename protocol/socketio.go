@@ -3,9 +3,9 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
-	"log"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -23,23 +23,120 @@ const (
 var (
 	ErrorWrongMessageType = errors.New("wrong message type")
 	ErrorWrongPacket      = errors.New("wrong packet")
+
+	socketIoMessageRe = regexp.MustCompile(`(?s)^(\d{1,2})(?:/([^,]*),)?(\d*)(\[.*)$`)
 )
 
 func Encode(msg *Message) (string, error) {
-	output := ""
-	output += strconv.Itoa(int(msg.EngineIoType))
+	data, err := AppendEncode(nil, msg, nil)
+	return string(data), err
+}
+
+/*
+Append encoded packet to dst, like Encode but without intermediate strings.
+codec nil means the one set by SetJSONCodec
+*/
+func AppendEncode(dst []byte, msg *Message, codec JSONCodec) ([]byte, error) {
+	codec = codecOrDefault(codec)
+	dst = strconv.AppendInt(dst, int64(msg.EngineIoType), 10)
 
 	if msg.SocketType == SocketMessageTypeNone {
-		return output, nil
+		return dst, nil
+	}
+	if msg.SocketType == SocketMessageTypeConnect {
+		return appendConnect(dst, msg, codec)
+	}
+	dst = strconv.AppendInt(dst, int64(msg.SocketType), 10)
+	dst = append(dst, '/')
+	dst = append(dst, msg.SocketEvent.NS...)
+	dst = append(dst, ',')
+	var err error
+	if msg.SocketType == SocketMessageTypeAck {
+		dst = strconv.AppendInt(dst, int64(msg.SocketEvent.ID), 10)
+		dst = append(dst, '[')
+		if msg.SocketEvent.EventContent != nil {
+			if dst, err = appendJSON(dst, codec, msg.SocketEvent.EventContent); err != nil {
+				return nil, err
+			}
+			if len(msg.SocketEvent.ExtraArgs) > 0 {
+				dst = append(dst, ',')
+			}
+		}
+		if dst, err = appendArgs(dst, codec, msg.SocketEvent.ExtraArgs); err != nil {
+			return nil, err
+		}
+		return append(dst, ']'), nil
+	}
+	if msg.SocketEvent.HasID {
+		dst = strconv.AppendInt(dst, int64(msg.SocketEvent.ID), 10)
+	} else {
+		dst = append(dst, '0')
+	}
+	if msg.SocketEvent.EventName == "" {
+		return dst, nil
 	}
-	output += strconv.Itoa(int(msg.SocketType))
-	output += "/" + msg.SocketEvent.NS + ",0"
-	if !(msg.SocketEvent.EventName == "" || msg.SocketEvent.EventContent == "") {
-		payload, _ := json.Marshal([2]interface{}{msg.SocketEvent.EventName, msg.SocketEvent.EventContent})
-		output += string(payload)
+	// an empty string content means no argument, extra ones are still sent
+	hasContent := msg.SocketEvent.EventContent != ""
+	if !hasContent && len(msg.SocketEvent.ExtraArgs) == 0 {
+		return dst, nil
+	}
+	dst = append(dst, '[')
+	dst = appendJSONString(dst, msg.SocketEvent.EventName)
+	if hasContent {
+		dst = append(dst, ',')
+		if dst, err = appendJSON(dst, codec, msg.SocketEvent.EventContent); err != nil {
+			return nil, err
+		}
 	}
+	if len(msg.SocketEvent.ExtraArgs) > 0 {
+		dst = append(dst, ',')
+		if dst, err = appendArgs(dst, codec, msg.SocketEvent.ExtraArgs); err != nil {
+			return nil, err
+		}
+	}
+	dst = append(dst, ']')
 
-	return output, nil
+	return dst, nil
+}
+
+/*
+Append comma separated JSON values
+*/
+func appendArgs(dst []byte, codec JSONCodec, args []interface{}) ([]byte, error) {
+	var err error
+	for i, arg := range args {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		if dst, err = appendJSON(dst, codec, arg); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+/*
+Arguments of an event packet as Encode writes them, an empty string content
+is left out. codec nil means the one set by SetJSONCodec
+*/
+func EventArgs(msg *Message, codec JSONCodec) ([]json.RawMessage, error) {
+	codec = codecOrDefault(codec)
+	args := make([]json.RawMessage, 0, len(msg.SocketEvent.ExtraArgs)+1)
+	if msg.SocketEvent.EventContent != "" {
+		raw, err := codec.Marshal(msg.SocketEvent.EventContent)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, raw)
+	}
+	for _, arg := range msg.SocketEvent.ExtraArgs {
+		raw, err := codec.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, raw)
+	}
+	return args, nil
 }
 
 func GetEngineMessageType(data string) (EngineMessageType, error) {
@@ -54,7 +151,7 @@ func GetEngineMessageType(data string) (EngineMessageType, error) {
 }
 
 func GetSocketMessageType(data string) (SocketMessageType, error) {
-	if len(data) == 0 {
+	if len(data) < 2 {
 		return 0, ErrorWrongMessageType
 	}
 	msgType, _ := strconv.Atoi(data[1:2])
@@ -64,50 +161,109 @@ func GetSocketMessageType(data string) (SocketMessageType, error) {
 	return SocketMessageType(msgType), nil
 }
 
+/*
+Event name of an event packet without decoding its arguments, empty if not found
+*/
+func GetEventName(data string) string {
+	start := strings.IndexByte(data, '[')
+	if start < 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(strings.NewReader(data[start:]))
+	if _, err := decoder.Token(); err != nil {
+		return ""
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return ""
+	}
+	name, _ := token.(string)
+	return name
+}
+
+/*
+Decode ack packet, e.g. 43/chat,5["ok"], its arguments go to Args
+*/
+func getSocketIoAck(data string, codec JSONCodec) (*Message, error) {
+	matches := socketIoMessageRe.FindStringSubmatch(data)
+	if len(matches) != 5 || matches[3] == "" {
+		return nil, ErrorWrongPacket
+	}
+	id, err := strconv.Atoi(matches[3])
+	if err != nil {
+		return nil, ErrorWrongPacket
+	}
+	var args []json.RawMessage
+	if err := codec.Unmarshal([]byte(matches[4]), &args); err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		EngineIoType: EngineMessageTypeMessage,
+		SocketType:   SocketMessageTypeAck,
+		SocketEvent: SocketEvent{
+			NS:    matches[2],
+			ID:    id,
+			HasID: true,
+			Args:  args,
+		},
+	}
+	if len(args) > 0 {
+		if err := codec.Unmarshal(args[0], &msg.SocketEvent.EventContent); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
 func GetSocketIoMessage(data string) (*Message, error) {
-	re := regexp.MustCompile(`(\d{1,2})/([^,]*),(.+)`)
-	matches := re.FindStringSubmatch(data)
-	if len(matches) == 4 {
-		// if len(matches[1]) == 2 {
-		// 	engineIoType, _ := strconv.Atoi(string(matches[1][0]))
-		// 	msgType, _ := strconv.Atoi(string(matches[1][1]))
-
-		// } else {
-		// 	engineIoType, _ := strconv.Atoi(string(matches[1]))
-		// 	engineIoType = engineIoType.(EngineMessageType)
-		// 	// msgType, _ := strconv.Atoi(string(matches[2]))
-		// 	msgType := SocketMessageTypeNone
-		// }
+	return getSocketIoMessage(data, defaultCodec)
+}
+
+func getSocketIoMessage(data string, codec JSONCodec) (*Message, error) {
+	// root namespace packets have no "/nsp," part, e.g. 42["event"]
+	matches := socketIoMessageRe.FindStringSubmatch(data)
+	if len(matches) == 5 {
 		namespace := matches[2]
-		jsonData := matches[3]
+		jsonData := matches[4]
+
+		id, hasID := 0, false
+		if matches[3] != "" {
+			var err error
+			if id, err = strconv.Atoi(matches[3]); err != nil {
+				return nil, ErrorWrongPacket
+			}
+			hasID = true
+		}
 
 		// 解析JSON数据
-		var result []interface{}
-		if err := json.Unmarshal([]byte(jsonData), &result); err != nil {
-			log.Fatalln("JSON parse error:", err)
+		var result []json.RawMessage
+		if err := codec.Unmarshal([]byte(jsonData), &result); err != nil {
 			return nil, err
 		}
+		if len(result) == 0 {
+			return nil, ErrorWrongPacket
+		}
 
-		eventName := result[0].(string)
+		var eventName string
+		if err := codec.Unmarshal(result[0], &eventName); err != nil {
+			return nil, err
+		}
 		var payload interface{}
-		switch result[1].(type) {
-		case string:
-			payload = result[1].(string)
-		case map[string]interface{}:
-			payload = result[1].(map[string]interface{})
-		case []interface{}:
-			payload = result[1].([]interface{})
-		default:
-			log.Fatalln("unknown type")
-			return nil, errors.New("unknown type")
+		if len(result) > 1 {
+			if err := codec.Unmarshal(result[1], &payload); err != nil {
+				return nil, err
+			}
 		}
 
 		return &Message{
 			EngineIoType: EngineMessageTypeMessage,
 			SocketType:   SocketMessageTypeEvent,
 			SocketEvent: SocketEvent{
+				ID:           id,
+				HasID:        hasID,
 				EventName:    eventName,
 				EventContent: payload,
+				Args:         result[1:],
 				NS:           namespace,
 			},
 		}, nil
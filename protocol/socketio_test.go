@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func eventMessage(name string, content interface{}, extra ...interface{}) *Message {
+	return &Message{
+		EngineIoType: EngineMessageTypeMessage,
+		SocketType:   SocketMessageTypeEvent,
+		SocketEvent:  SocketEvent{NS: "chat", EventName: name, EventContent: content, ExtraArgs: extra},
+	}
+}
+
+func TestEncodeEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+		want string
+	}{
+		{"content", eventMessage("say", "hi"), `42/chat,0["say","hi"]`},
+		{"no content", eventMessage("say", ""), `42/chat,0`},
+		{"no name", eventMessage("", "hi"), `42/chat,0`},
+		{"extra args", eventMessage("say", "hi", 1, true), `42/chat,0["say","hi",1,true]`},
+		{"extra args without content", eventMessage("say", "", map[string]string{"traceparent": "x"}), `42/chat,0["say",{"traceparent":"x"}]`},
+		{"nil content", eventMessage("say", nil), `42/chat,0["say",null]`},
+		{"ack requested", &Message{EngineIoType: EngineMessageTypeMessage, SocketType: SocketMessageTypeEvent,
+			SocketEvent: SocketEvent{NS: "chat", ID: 12, HasID: true, EventName: "say", EventContent: "hi"}}, `42/chat,12["say","hi"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Encode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+*
+encoding/json codec without AppendMarshal, encodes through json.Marshal
+*/
+type marshalCodec struct{}
+
+func (marshalCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (marshalCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func TestAppendEncodeEquivalence(t *testing.T) {
+	ack := &Message{EngineIoType: EngineMessageTypeMessage, SocketType: SocketMessageTypeAck,
+		SocketEvent: SocketEvent{NS: "chat", ID: 17, EventContent: map[string]int{"ok": 1}, ExtraArgs: []interface{}{"x"}}}
+	rootAck := &Message{EngineIoType: EngineMessageTypeMessage, SocketType: SocketMessageTypeAck,
+		SocketEvent: SocketEvent{ID: 0}}
+	connect := &Message{EngineIoType: EngineMessageTypeMessage, SocketType: SocketMessageTypeConnect,
+		SocketEvent: SocketEvent{NS: "admin", EventContent: map[string]string{"token": "<a&b>"}}}
+	rootEvent := eventMessage("say", "hi")
+	rootEvent.SocketEvent.NS = ""
+	type payload struct {
+		Name  string  `json:"name"`
+		Skip  string  `json:"-"`
+		Score float64 `json:"score,omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want string
+	}{
+		{"ping", &Message{EngineIoType: EngineMessageTypePing, SocketType: SocketMessageTypeNone}, `2`},
+		{"root namespace", rootEvent, `42/,0["say","hi"]`},
+		{"namespace", eventMessage("say", 1), `42/chat,0["say",1]`},
+		{"html escaping", eventMessage("<b>", "a&b<c>"), `42/chat,0["\u003cb\u003e","a\u0026b\u003cc\u003e"]`},
+		{"control characters", eventMessage("a\nb\tc\x01\b", "\"quoted\"\\\f"), `42/chat,0["a\nb\tc\u0001\b","\"quoted\"\\\f"]`},
+		{"line separators", eventMessage("a\u2028b\u2029", "\u2028"), `42/chat,0["a\u2028b\u2029","\u2028"]`},
+		{"invalid utf-8", eventMessage("a\xffb", "c\xfed"), "42/chat,0[\"a�b\",\"c�d\"]"},
+		{"unicode", eventMessage("日本", "😀"), `42/chat,0["日本","😀"]`},
+		{"struct tags", eventMessage("user", payload{Name: "x", Skip: "y"}), `42/chat,0["user",{"name":"x"}]`},
+		{"extra args", eventMessage("say", "hi", 1, nil, []int{2}), `42/chat,0["say","hi",1,null,[2]]`},
+		{"ack with id", ack, `43/chat,17[{"ok":1},"x"]`},
+		{"ack without content", rootAck, `43/,0[]`},
+		{"connect with auth", connect, `40/admin,{"token":"\u003ca\u0026b\u003e"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := Encode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded != tt.want {
+				t.Fatalf("Encode = %s, want %s", encoded, tt.want)
+			}
+			// pooled json.Encoder path after existing buffer content
+			appended, err := AppendEncode([]byte("prefix"), tt.msg, StdJSONCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(appended) != "prefix"+encoded {
+				t.Fatalf("AppendEncode = %s, want prefix%s", appended, encoded)
+			}
+			// json.Marshal path
+			marshalled, err := AppendEncode(nil, tt.msg, marshalCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(marshalled) != encoded {
+				t.Fatalf("AppendEncode with Marshal = %s, want %s", marshalled, encoded)
+			}
+			parser := JSONParser{}
+			data, _, err := parser.Encode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pooled, _, err := parser.AppendEncode(make([]byte, 0, 512), tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, pooled) || string(data) != encoded {
+				t.Fatalf("JSONParser Encode = %s, AppendEncode = %s, want %s", data, pooled, encoded)
+			}
+		})
+	}
+}
+
+func TestAppendJSONStringMatchesEncodingJSON(t *testing.T) {
+	for _, s := range []string{"", "plain", "<>&", "\"\\/", "\x00\x1f\x7f", "\n\r\t\b\f", "  ", "é日😀", "a\xffb", "\xed\xa0\x80", "\xf0\x9f"} {
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appendJSONString(nil, s); !bytes.Equal(got, want) {
+			t.Errorf("appendJSONString(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestDecodeAck(t *testing.T) {
+	tests := []struct {
+		name string
+		pkg  string
+		ns   string
+		id   int
+		args []string
+	}{
+		{"namespace", `43/chat,12["ok",1]`, "chat", 12, []string{`"ok"`, `1`}},
+		{"root namespace", `435[{"a":true}]`, "", 5, []string{`{"a":true}`}},
+		{"no argument", `43/chat,0[]`, "chat", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := JSONParser{}.Decode([]byte(tt.pkg), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.SocketType != SocketMessageTypeAck || !msg.SocketEvent.HasID || msg.SocketEvent.ID != tt.id || msg.SocketEvent.NS != tt.ns {
+				t.Fatalf("decoded %+v", msg)
+			}
+			if len(msg.SocketEvent.Args) != len(tt.args) {
+				t.Fatalf("args = %s, want %s", msg.SocketEvent.Args, tt.args)
+			}
+			for i, arg := range msg.SocketEvent.Args {
+				if string(arg) != tt.args[i] {
+					t.Fatalf("args = %s, want %s", msg.SocketEvent.Args, tt.args)
+				}
+			}
+		})
+	}
+
+	for _, pkg := range []string{`43/chat,["ok"]`, `43/chat,1{"a":1}`} {
+		if msg, err := (JSONParser{}).Decode([]byte(pkg), false); err == nil {
+			t.Fatalf("Decode(%s) = %+v, want an error", pkg, msg)
+		}
+	}
+}
+
+func TestEventArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+		want []string
+	}{
+		{"content", eventMessage("say", "hi", 2), []string{`"hi"`, `2`}},
+		{"empty content left out", eventMessage("say", "", 2), []string{`2`}},
+		{"no argument", eventMessage("say", ""), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := EventArgs(tt.msg, marshalCodec{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != len(tt.want) {
+				t.Fatalf("args = %s, want %s", args, tt.want)
+			}
+			for i, arg := range args {
+				if string(arg) != tt.want[i] {
+					t.Fatalf("args = %s, want %s", args, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package socketioclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorRateLimited = errors.New("Rate limit exceeded")
+	// packet dropped under RateLimitDrop, nothing was sent
+	ErrorRateLimitDropped = errors.New("Rate limited packet dropped")
+)
+
+/*
+*
+What an emit over the rate limit does
+*/
+type RateLimitMode int
+
+const (
+	// EmitContext and EmitWithAck wait for a token, until ctx is done or the
+	// connection closed. Emit and TryEmit never wait, they fail with
+	// ErrorRateLimited as under RateLimitError, volatile emits are dropped
+	RateLimitDelay RateLimitMode = iota
+	// drop the packet, Emit returns ErrorRateLimitDropped. Volatile emits
+	// return nil as for their other drops
+	RateLimitDrop
+	// fail with ErrorRateLimited
+	RateLimitError
+)
+
+/*
+*
+Token bucket settings: Rate emits per second on average, bursts of up to
+Burst emits. Zero Rate means unlimited
+*/
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+/*
+*
+Take a token, going into debt if there is none, and return how long to wait
+until it is really available
+*/
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+/*
+*
+Take a token if one is available
+*/
+func (b *tokenBucket) take(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/*
+*
+Give back a token taken for an emit that did not happen
+*/
+func (b *tokenBucket) refund() {
+	b.lock.Lock()
+	b.tokens = min(b.burst, b.tokens+1)
+	b.lock.Unlock()
+}
+
+/*
+*
+Tokens taken for one emit, given back when the packet is not queued after all
+*/
+type rateTokens []*tokenBucket
+
+func (t rateTokens) refund() {
+	for _, b := range t {
+		b.refund()
+	}
+}
+
+/*
+*
+Limiter in front of Client emits, one bucket for the client and one per
+configured event. An emit needs a token of both
+*/
+type rateLimiter struct {
+	mode   RateLimitMode
+	client *tokenBucket
+	// fixed once configured, read without lock
+	events map[string]*tokenBucket
+}
+
+/*
+*
+Limiter for conf, nil if no limit is set
+*/
+func newRateLimiter(conf ConConf) *rateLimiter {
+	l := &rateLimiter{mode: conf.RateLimitMode}
+	if conf.RateLimit.Rate > 0 {
+		l.client = newTokenBucket(conf.RateLimit)
+	}
+	for event, limit := range conf.EventRateLimits {
+		if limit.Rate <= 0 {
+			continue
+		}
+		if l.events == nil {
+			l.events = make(map[string]*tokenBucket)
+		}
+		l.events[event] = newTokenBucket(limit)
+	}
+	if l.client == nil && l.events == nil {
+		return nil
+	}
+	return l
+}
+
+/*
+*
+Wait for the right to emit event according to the limiter mode and return
+the tokens taken. Returns ErrorRateLimitDropped for RateLimitDrop. Emits that
+must not block never wait: volatile ones are dropped, Emit and TryEmit fail
+*/
+func (l *rateLimiter) wait(ctx context.Context, closed <-chan struct{}, event string, stats Metrics, queue queueMode) (rateTokens, error) {
+	if l == nil {
+		return nil, nil
+	}
+	mode := l.mode
+	if mode == RateLimitDelay && queue == queueVolatile {
+		mode = RateLimitDrop
+	} else if mode == RateLimitDelay && queue == queueTry {
+		mode = RateLimitError
+	}
+	buckets := make(rateTokens, 0, 2)
+	if b := l.events[event]; b != nil {
+		buckets = append(buckets, b)
+	}
+	if l.client != nil {
+		buckets = append(buckets, l.client)
+	}
+	now := time.Now()
+
+	if mode != RateLimitDelay {
+		for i, b := range buckets {
+			if b.take(now) {
+				continue
+			}
+			buckets[:i].refund()
+			if mode == RateLimitDrop {
+				stats.AddCounter(MetricThrottled, 1, throttleLabels(event, "dropped"))
+				return nil, ErrorRateLimitDropped
+			}
+			stats.AddCounter(MetricThrottled, 1, throttleLabels(event, "rejected"))
+			return nil, ErrorRateLimited
+		}
+		return buckets, nil
+	}
+
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.reserve(now))
+	}
+	if delay == 0 {
+		return buckets, nil
+	}
+	stats.AddCounter(MetricThrottled, 1, throttleLabels(event, "delayed"))
+	stats.ObserveHistogram(MetricThrottleDelay, delay.Seconds(), eventLabels(event))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return buckets, nil
+	case <-ctx.Done():
+		buckets.refund()
+		return nil, ctx.Err()
+	case <-closed:
+		buckets.refund()
+		return nil, ErrorSocketClosed
+	}
+}
+
+func throttleLabels(event string, action string) map[string]string {
+	return map[string]string{"event": event, "action": action}
+}
@@ -0,0 +1,174 @@
+package socketioclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+/*
+*
+Tokens left in the client bucket of c
+*/
+func tokens(c *Client) float64 {
+	b := c.limiter.client
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.tokens
+}
+
+func TestRateLimitError(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{RateLimit: RateLimit{Rate: 1, Burst: 2}, RateLimitMode: RateLimitError, Metrics: metrics})
+	for i := 0; i < 2; i++ {
+		if err := c.Emit("event", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Emit("event", 2); err != ErrorRateLimited {
+		t.Fatalf("Emit over the limit = %v, want ErrorRateLimited", err)
+	}
+	if got := queued(c); len(got) != 2 {
+		t.Fatalf("queued %d packets, want 2", len(got))
+	}
+	if n := metrics.counter(MetricThrottled); n != 1 {
+		t.Fatalf("throttled = %v, want 1", n)
+	}
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{RateLimit: RateLimit{Rate: 1, Burst: 1}, RateLimitMode: RateLimitDrop, Metrics: metrics})
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("event", 2); err != ErrorRateLimitDropped {
+		t.Fatalf("Emit over the limit = %v, want ErrorRateLimitDropped", err)
+	}
+	// volatile drops are not reported to the caller
+	if err := c.Volatile().Emit("event", 3); err != nil {
+		t.Fatalf("volatile Emit over the limit = %v, want nil", err)
+	}
+	if got := queued(c); len(got) != 1 {
+		t.Fatalf("queued %d packets, want 1", len(got))
+	}
+	if n := metrics.counter(MetricThrottled); n != 2 {
+		t.Fatalf("throttled = %v, want 2", n)
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	c := newTestClient(ConConf{RateLimit: RateLimit{Rate: 50, Burst: 1}, RateLimitMode: RateLimitDelay})
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	// Emit never waits, even under RateLimitDelay
+	if err := c.Emit("event", 2); err != ErrorRateLimited {
+		t.Fatalf("Emit over the limit = %v, want ErrorRateLimited", err)
+	}
+	start := time.Now()
+	if err := c.EmitContext(context.Background(), "event", 3); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 10*time.Millisecond {
+		t.Fatalf("EmitContext waited %v for a token, want about 20ms", waited)
+	}
+	if got := queued(c); len(got) != 2 {
+		t.Fatalf("queued %d packets, want 2", len(got))
+	}
+}
+
+func TestRateLimitRefundWhenWaitEnds(t *testing.T) {
+	c := newTestClient(ConConf{RateLimit: RateLimit{Rate: 1, Burst: 1}, RateLimitMode: RateLimitDelay})
+	c.startSession()
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.EmitContext(ctx, "event", 2); err != context.DeadlineExceeded {
+		t.Fatalf("EmitContext = %v, want context.DeadlineExceeded", err)
+	}
+	if left := tokens(c); left < 0 {
+		t.Fatalf("%v tokens after a cancelled wait, the reserved one was not refunded", left)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.EmitContext(context.Background(), "event", 3) }()
+	time.Sleep(10 * time.Millisecond)
+	c.endSession()
+	select {
+	case err := <-done:
+		if err != ErrorSocketClosed {
+			t.Fatalf("EmitContext = %v, want ErrorSocketClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitContext still waiting after the connection closed")
+	}
+	if left := tokens(c); left < 0 {
+		t.Fatalf("%v tokens after the connection closed, the reserved one was not refunded", left)
+	}
+}
+
+func TestRateLimitRefundWhenNotQueued(t *testing.T) {
+	tests := []struct {
+		name string
+		conf ConConf
+		emit func(c *Client) error
+	}{
+		{"middleware drop", ConConf{}, func(c *Client) error {
+			c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+				return errors.New("blocked")
+			})
+			return c.Emit("event", 1)
+		}},
+		{"volatile drop", ConConf{VolatileThreshold: 1}, func(c *Client) error {
+			// the queue fills up after the writable check
+			c.UseOutgoing(func(ctx context.Context, p *Packet, next NextFunc) error {
+				c.out <- textFrame("42[\"a\"]")
+				c.out <- textFrame("42[\"b\"]")
+				return next(ctx, p)
+			})
+			return c.Volatile().Emit("event", 1)
+		}},
+		{"queue full", ConConf{QueueSize: 1}, func(c *Client) error {
+			c.out <- textFrame("42[\"full\"]")
+			return c.Emit("event", 1)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.RateLimit = RateLimit{Rate: 0.001, Burst: 1}
+			tt.conf.RateLimitMode = RateLimitError
+			c := newTestClient(tt.conf)
+			c.setAliveValue(true)
+			tt.emit(c)
+			if left := tokens(c); left != 1 {
+				t.Fatalf("%v tokens after a packet that was not queued, want 1", left)
+			}
+		})
+	}
+}
+
+func TestEventRateLimit(t *testing.T) {
+	c := newTestClient(ConConf{
+		RateLimit:       RateLimit{Rate: 1, Burst: 2},
+		EventRateLimits: map[string]RateLimit{"chatty": {Rate: 1, Burst: 1}},
+		RateLimitMode:   RateLimitError,
+	})
+	if err := c.Emit("chatty", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("chatty", 2); err != ErrorRateLimited {
+		t.Fatalf("Emit over the event limit = %v, want ErrorRateLimited", err)
+	}
+	// the rejected emit did not use a token of the client bucket
+	if err := c.Emit("other", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("other", 4); err != ErrorRateLimited {
+		t.Fatalf("Emit over the client limit = %v, want ErrorRateLimited", err)
+	}
+}
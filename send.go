@@ -1,8 +1,10 @@
 package socketioclient
 
 import (
+	"context"
 	"errors"
 	"log"
+	"sync"
 
 	"github.com/liangsqrt/socketio-client-go/protocol"
 )
@@ -10,34 +12,138 @@ import (
 var (
 	ErrorSendTimeout     = errors.New("Timeout")
 	ErrorSocketOverflood = errors.New("Socket overflood")
+	ErrorSocketClosed    = errors.New("Socket closed")
 )
 
 /*
 *
-Send message packet to socket
+What to do with a packet when the outbound queue is full
 */
-func send(msg string, c *Channel) error {
+type queueMode int
+
+const (
+	// fail with ErrorSocketOverflood
+	queueTry queueMode = iota
+	// wait for room until ctx is done or the connection closed
+	queueBlock
+	// drop with errVolatileDropped above the volatile threshold
+	queueVolatile
+)
+
+// packet of a volatile emit was not queued, not reported to the caller
+var errVolatileDropped = errors.New("volatile packet dropped")
+
+/*
+*
+Outgoing websocket frame, buf is the pooled buffer backing data if any
+*/
+type frame struct {
+	data   []byte
+	binary bool
+	buf    *[]byte
+}
+
+// frames above this size are not kept in the pool
+const maxPooledFrame = 64 * 1024
+
+var framePool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, 512)
+	return &buf
+}}
+
+/*
+*
+Give the buffer of a written or dropped frame back to the pool
+*/
+func (f frame) release() {
+	if f.buf == nil || cap(f.data) > maxPooledFrame {
+		return
+	}
+	*f.buf = f.data[:0]
+	framePool.Put(f.buf)
+}
+
+func textFrame(msg string) frame {
+	return frame{data: []byte(msg)}
+}
+
+/*
+*
+Send control packet to socket through the high priority lane, failing if
+the lane is full. Drops are logged and counted, packet names the packet
+*/
+func send(msg frame, c *Channel, packet string) error {
+	err := enqueue(context.Background(), msg, c, queueTry, true)
+	if err != nil {
+		controlDropped(c, packet, err)
+	}
+	return err
+}
+
+/*
+*
+Report a control packet the queue did not take, the server may time out
+the connection or the ack
+*/
+func controlDropped(c *Channel, packet string, err error) {
+	log.Println("socket.io", packet, "dropped:", err)
+	c.stats().AddCounter(MetricControlDropped, 1, map[string]string{"packet": packet})
+}
+
+/*
+*
+Queue message packet for outLoop, in the high priority lane if high
+*/
+func enqueue(ctx context.Context, msg frame, c *Channel, mode queueMode, high bool) (err error) {
 	//preventing json/encoding "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("socket.io send panic: ", r)
+			err = ErrorSocketClosed
 		}
 	}()
 
-	if len(c.out) == queueBufferSize {
-		return ErrorSocketOverflood
+	// the threshold counts both lanes, like the high-water mark
+	if mode == queueVolatile && c.QueueLen() > c.volatileThreshold {
+		return errVolatileDropped
+	}
+	out := c.out
+	if high {
+		out = c.outHigh
+	}
+	select {
+	case out <- msg:
+	default:
+		if mode == queueVolatile {
+			return errVolatileDropped
+		}
+		c.stats().AddCounter(MetricQueueFull, 1, nil)
+		if mode == queueTry {
+			return ErrorSocketOverflood
+		}
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.Context().Done():
+			return ErrorSocketClosed
+		}
 	}
 
-	c.out <- msg
-
+	if depth := c.QueueLen(); depth >= c.queueHighWater && c.overflooded.CompareAndSwap(false, true) {
+		if c.onHighWater != nil {
+			c.onHighWater(depth, true)
+		}
+	}
 	return nil
 }
 
 /*
 *
-Create packet based on given data and send it
+Create packet based on given data and send it, asking the server for an ack
+with ackID unless it is 0
 */
-func (c *Channel) emit(method string, namespace string, args interface{}) error {
+func (c *Channel) emit(ctx context.Context, method string, namespace string, args interface{}, ackID int, mode queueMode, high bool) (err error) {
 
 	msg := protocol.Message{
 		EngineIoType: protocol.EngineMessageTypeMessage,
@@ -47,14 +153,67 @@ func (c *Channel) emit(method string, namespace string, args interface{}) error
 	msg.SocketEvent.EventName = method
 	msg.SocketEvent.NS = namespace
 	msg.SocketEvent.EventContent = args
+	if ackID != 0 {
+		msg.SocketEvent.ID = ackID
+		msg.SocketEvent.HasID = true
+	}
 
-	command, err := protocol.Encode(&msg)
-	if err != nil {
-		return err
+	ctx, end := c.trace().StartEmit(ctx, &msg)
+	defer func() { end(err) }()
+	// the trace carrier is added back once the middlewares ran, they and the
+	// outgoing listeners only see the caller arguments
+	carrier := msg.SocketEvent.ExtraArgs
+	msg.SocketEvent.ExtraArgs = nil
+
+	// panics of outgoing middlewares and listeners are returned to the caller
+	return c.safeRun(withEvent(ctx, &msg), func() error {
+		return c.middlewares.runOutgoing(ctx, &msg, c.emitFinal(mode, high, carrier))
+	})
+}
+
+/*
+*
+End of the outgoing middleware chain, adds the trace carrier and queues the packet
+*/
+func (c *Channel) emitFinal(mode queueMode, high bool, carrier []interface{}) NextFunc {
+	return func(ctx context.Context, p *Packet) error {
+		notify := c.anyListeners.prepareOutgoing(c, p)
+		p.SocketEvent.ExtraArgs = append(p.SocketEvent.ExtraArgs, carrier...)
+		err := c.sendPacket(ctx, p, mode, high || c.highPriorityEvents[p.SocketEvent.EventName])
+		if err != nil {
+			return err
+		}
+		notify(withEvent(ctx, p))
+		c.stats().AddCounter(MetricMessagesSent, 1, eventLabels(p.SocketEvent.EventName))
+		return nil
 	}
+}
 
-	err = send(command, c)
+/*
+*
+Encode socket.io packet with the channel parser and send it, into a pooled
+buffer when the parser supports it
+*/
+func (c *Channel) sendPacket(ctx context.Context, msg *protocol.Message, mode queueMode, high bool) error {
+	parser := c.parser()
+	encoder, ok := parser.(protocol.AppendEncoder)
+	if !ok {
+		data, binary, err := parser.Encode(msg)
+		if err != nil {
+			return err
+		}
+		return enqueue(ctx, frame{data: data, binary: binary}, c, mode, high)
+	}
+
+	buf := framePool.Get().(*[]byte)
+	data, binary, err := encoder.AppendEncode((*buf)[:0], msg)
+	f := frame{data: data, binary: binary, buf: buf}
 	if err != nil {
+		f.release()
+		return err
+	}
+	if err := enqueue(ctx, f, c, mode, high); err != nil {
+		f.release()
 		return err
 	}
 	return nil
@@ -0,0 +1,145 @@
+package socketioclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Parser hiding AppendEncode, packets are encoded with Encode
+*/
+type encodeOnlyParser struct {
+	protocol.Parser
+}
+
+func TestSendPacketPooledMatchesEncode(t *testing.T) {
+	args := []interface{}{"hi", map[string]interface{}{"html": "<a&b>", "n": 1.5}, nil, []int{1, 2}}
+	pooled := newTestClient(ConConf{})
+	plain := newTestClient(ConConf{Parser: encodeOnlyParser{protocol.JSONParser{}}})
+	for _, arg := range args {
+		if err := pooled.TryEmit("event", arg); err != nil {
+			t.Fatal(err)
+		}
+		if err := plain.TryEmit("event", arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, want := queued(pooled), queued(plain)
+	if len(got) != len(args) || len(got) != len(want) {
+		t.Fatalf("queued %d and %d packets, want %d", len(got), len(want), len(args))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("pooled packet %s, Encode gives %s", got[i], want[i])
+		}
+	}
+}
+
+func TestEmitCountsSentMessages(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{Metrics: metrics})
+	if err := c.TryEmit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := metrics.counter(MetricMessagesSent); n != 1 {
+		t.Fatalf("sent = %v, want 1", n)
+	}
+
+	// without metrics the noop sink is used
+	c = newTestClient(ConConf{})
+	if err := c.TryEmit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmitFailsFastOnFullQueue(t *testing.T) {
+	c := newTestClient(ConConf{QueueSize: 1})
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit("event", 2); err != ErrorSocketOverflood {
+		t.Fatalf("Emit on a full queue = %v, want ErrorSocketOverflood", err)
+	}
+	if err := c.TryEmit("event", 3); err != ErrorSocketOverflood {
+		t.Fatalf("TryEmit on a full queue = %v, want ErrorSocketOverflood", err)
+	}
+}
+
+func TestEmitContextWaitsForRoom(t *testing.T) {
+	c := newTestClient(ConConf{QueueSize: 1})
+	if err := c.Emit("event", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.EmitContext(ctx, "event", 2); err != context.DeadlineExceeded {
+		t.Fatalf("EmitContext on a full queue = %v, want context.DeadlineExceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.EmitContext(context.Background(), "event", 3) }()
+	select {
+	case err := <-done:
+		t.Fatalf("EmitContext returned %v before the queue had room", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	(<-c.out).release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EmitContext still waiting after the queue had room")
+	}
+	if got := queued(c); len(got) != 1 || !strings.Contains(got[0], "3") {
+		t.Fatalf("queued %q, want the third emit", got)
+	}
+}
+
+func TestQueueHighWater(t *testing.T) {
+	var depths []int
+	c := newTestClient(ConConf{QueueSize: 4, QueueHighWater: 2, OnQueueHighWater: func(depth int, high bool) {
+		if high {
+			depths = append(depths, depth)
+		}
+	}})
+	for i := 0; i < 3; i++ {
+		if err := c.Emit("event", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(depths) != 1 || depths[0] != 2 {
+		t.Fatalf("high-water called at %v, want once at 2", depths)
+	}
+	if !c.Overflooded() {
+		t.Fatal("queue above its high-water mark not reported")
+	}
+}
+
+func TestControlDropCounted(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{QueueSize: 1, Metrics: metrics})
+	if err := send(textFrame(protocol.PingMessage), &c.Channel, "pong"); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(textFrame(protocol.PingMessage), &c.Channel, "pong"); err != ErrorSocketOverflood {
+		t.Fatalf("send on a full lane = %v, want ErrorSocketOverflood", err)
+	}
+	if n := metrics.counter(MetricControlDropped); n != 1 {
+		t.Fatalf("control dropped = %v, want 1", n)
+	}
+
+	// acks share the lane and are counted the same way
+	c.On("value", func(c *Channel) int { return 7 })
+	receive(c, `42/chat,2["value"]`)
+	if n := metrics.counter(MetricControlDropped); n != 2 {
+		t.Fatalf("control dropped = %v after a dropped ack, want 2", n)
+	}
+}
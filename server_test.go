@@ -0,0 +1,101 @@
+package socketioclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+*
+Minimal Engine.IO v4 server: polling handshake, namespace CONNECT by POST
+and websocket upgrade. It records the requests it got
+*/
+type testServer struct {
+	*httptest.Server
+	sids atomic.Int64
+
+	lock     sync.Mutex
+	requests []*http.Request
+	posts    []string
+	received []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r)
+	s.lock.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case query.Get("transport") == "websocket":
+		s.serveWebsocket(w, r)
+	case r.Method == "POST":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		s.posts = append(s.posts, string(body))
+		s.lock.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "connected"})
+		w.Write([]byte("ok"))
+	case query.Get("sid") == "":
+		sid := "sid" + strconv.FormatInt(s.sids.Add(1), 10)
+		http.SetCookie(w, &http.Cookie{Name: "io", Value: sid})
+		w.Write([]byte(`0{"sid":"` + sid + `","pingInterval":25000,"pingTimeout":20000}`))
+	default:
+		w.Write([]byte("40"))
+	}
+}
+
+func (s *testServer) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		message := string(data)
+		switch message {
+		case "2probe":
+			conn.WriteMessage(websocket.TextMessage, []byte("3probe"))
+		case "5":
+			conn.WriteMessage(websocket.TextMessage, []byte("6"))
+		default:
+			s.lock.Lock()
+			s.received = append(s.received, message)
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *testServer) postBodies() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.posts...)
+}
+
+func (s *testServer) allRequests() []*http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*http.Request{}, s.requests...)
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	socketioclient "github.com/liangsqrt/socketio-client-go"
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+Minimal Engine.IO v4 server counting the event packets it receives
+*/
+func newSinkServer(b *testing.B, received *atomic.Int64) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("transport") == "websocket":
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer ws.Close()
+			ws.ReadMessage()
+			ws.WriteMessage(websocket.TextMessage, []byte("3probe"))
+			ws.ReadMessage()
+			ws.WriteMessage(websocket.TextMessage, []byte("6"))
+			for {
+				_, data, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				if len(data) > 1 && data[1] == '2' {
+					received.Add(1)
+				}
+			}
+		case r.Method == http.MethodPost:
+			w.Write([]byte("ok"))
+		case query.Get("sid") == "":
+			w.Write([]byte(`0{"sid":"bench","pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`))
+		default:
+			w.Write([]byte("40"))
+		}
+	}))
+}
+
+/*
+Encoding cost of the emit path, benchmarks/codec compares codecs on a larger payload
+*/
+func BenchmarkEncode(b *testing.B) {
+	msg := &protocol.Message{
+		EngineIoType: protocol.EngineMessageTypeMessage,
+		SocketType:   protocol.SocketMessageTypeEvent,
+	}
+	msg.SocketEvent.NS = "proxy"
+	msg.SocketEvent.EventName = "node_traffic_usage"
+	msg.SocketEvent.EventContent = map[string]interface{}{"node_id": "node-0001", "upload": 1 << 20, "online": true}
+	b.Run("Encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := protocol.Encode(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("AppendEncode", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = protocol.AppendEncode(buf[:0], msg, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+/*
+Wait until the server got sent events, keeps the outbound queue from overflowing
+*/
+func waitReceived(b *testing.B, received *atomic.Int64, sent int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for received.Load() < sent {
+		if time.Now().After(deadline) {
+			b.Fatalf("server received %d of %d events", received.Load(), sent)
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+/*
+Allocations per Emit, including encoding and the write to the websocket
+*/
+func BenchmarkEmit(b *testing.B) {
+	var received atomic.Int64
+	server := newSinkServer(b, &received)
+	defer server.Close()
+
+	client, err := socketioclient.New(server.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	payload := map[string]interface{}{"node_id": "node-0001", "upload": 1 << 20, "online": true}
+	const batch = 1000
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.Emit("node_traffic_usage", payload); err != nil {
+			b.Fatal(err)
+		}
+		if (i+1)%batch == 0 || i == b.N-1 {
+			waitReceived(b, &received, int64(i+1))
+		}
+	}
+}
+
+/*
+Events per second delivered to the server, with and without write batching
+*/
+func BenchmarkEmitThroughput(b *testing.B) {
+	for _, writeBatch := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("batch=%d", writeBatch), func(b *testing.B) {
+			var received atomic.Int64
+			server := newSinkServer(b, &received)
+			defer server.Close()
+
+			client, err := socketioclient.New(server.URL, socketioclient.WithWriteBatch(writeBatch))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer client.Close()
+
+			payload := map[string]interface{}{"node_id": "node-0001", "upload": 1 << 20}
+			const window = 5000
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if err := client.Emit("node_traffic_usage", payload); err != nil {
+					b.Fatal(err)
+				}
+				if i >= window && i%1000 == 0 {
+					waitReceived(b, &received, int64(i-window))
+				}
+			}
+			waitReceived(b, &received, int64(b.N))
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package socketioclient
+
+import (
+	"context"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Tracer is called around every emitted and every received event.
+
+StartEmit may add trace context to msg before it is encoded, StartEvent may
+read it from msg.SocketEvent.Args before middlewares and listeners see them.
+The returned function ends the span with the emit or handler error. See the tracing package for an OpenTelemetry implementation
+*/
+type Tracer interface {
+	StartEmit(ctx context.Context, msg *protocol.Message) (context.Context, func(err error))
+	StartEvent(ctx context.Context, msg *protocol.Message) (context.Context, func(err error))
+}
+
+type noopTracer struct{}
+
+func (noopTracer) StartEmit(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
+
+func (noopTracer) StartEvent(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
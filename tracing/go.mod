@@ -0,0 +1,11 @@
+module github.com/liangsqrt/socketio-client-go/tracing
+
+go 1.22.5
+
+require (
+	github.com/liangsqrt/socketio-client-go v0.0.0
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+)
+
+replace github.com/liangsqrt/socketio-client-go => ..
@@ -0,0 +1,190 @@
+/*
+Package tracing implements socketioclient.Tracer with OpenTelemetry.
+
+Trace context is carried as W3C traceparent/tracestate, either in an extra
+trailing event argument (the default) or inside a map payload:
+
+	conf.Tracer = tracing.New(tracerProvider)
+*/
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+const instrumentationName = "github.com/liangsqrt/socketio-client-go/tracing"
+
+/*
+*
+Where the trace context is written on emit
+*/
+type Carrier int
+
+const (
+	// append a {"traceparent": ...} object as the last event argument
+	CarrierArgument Carrier = iota
+	// add traceparent/tracestate keys to map payloads, other payloads fall back to CarrierArgument
+	CarrierPayload
+)
+
+type Option func(*Tracer)
+
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(t *Tracer) { t.propagator = p }
+}
+
+func WithCarrier(c Carrier) Option {
+	return func(t *Tracer) { t.carrier = c }
+}
+
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	carrier    Carrier
+}
+
+/*
+*
+Create tracer using spans from tp, W3C trace context and CarrierArgument by default
+*/
+func New(tp trace.TracerProvider, opts ...Option) *Tracer {
+	t := &Tracer{
+		tracer:     tp.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+		carrier:    CarrierArgument,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+/*
+*
+Start producer span for an emit and inject its context into msg
+*/
+func (t *Tracer) StartEmit(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	ctx, span := t.tracer.Start(ctx, msg.SocketEvent.EventName+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(eventAttributes(msg)...),
+	)
+
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		t.inject(msg, carrier)
+	}
+	return ctx, endFunc(span)
+}
+
+/*
+*
+Start consumer span for a handled event, child of the context extracted from msg
+*/
+func (t *Tracer) StartEvent(ctx context.Context, msg *protocol.Message) (context.Context, func(err error)) {
+	if carrier, ok := extract(msg); ok {
+		ctx = t.propagator.Extract(ctx, carrier)
+	}
+	ctx, span := t.tracer.Start(ctx, msg.SocketEvent.EventName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(eventAttributes(msg)...),
+	)
+	return ctx, endFunc(span)
+}
+
+func (t *Tracer) inject(msg *protocol.Message, carrier propagation.MapCarrier) {
+	if t.carrier == CarrierPayload {
+		if payload, ok := mergePayload(msg.SocketEvent.EventContent, carrier); ok {
+			msg.SocketEvent.EventContent = payload
+			return
+		}
+	}
+	msg.SocketEvent.ExtraArgs = append(msg.SocketEvent.ExtraArgs, map[string]string(carrier))
+}
+
+/*
+*
+Copy map payload adding carrier keys, the caller's map is left untouched
+*/
+func mergePayload(content interface{}, carrier propagation.MapCarrier) (interface{}, bool) {
+	merged := map[string]interface{}{}
+	switch payload := content.(type) {
+	case map[string]interface{}:
+		for k, v := range payload {
+			merged[k] = v
+		}
+	case map[string]string:
+		for k, v := range payload {
+			merged[k] = v
+		}
+	default:
+		return nil, false
+	}
+	for k, v := range carrier {
+		merged[k] = v
+	}
+	return merged, true
+}
+
+/*
+*
+Read trace context from the last event argument. An argument holding nothing
+but trace context is removed so handlers do not see it
+*/
+func extract(msg *protocol.Message) (propagation.MapCarrier, bool) {
+	args := msg.SocketEvent.Args
+	if len(args) == 0 {
+		return nil, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(args[len(args)-1], &fields); err != nil {
+		return nil, false
+	}
+	if _, ok := fields["traceparent"]; !ok {
+		return nil, false
+	}
+
+	carrier := propagation.MapCarrier{}
+	onlyTrace := true
+	for k, v := range fields {
+		s, isString := v.(string)
+		if isString && (k == "traceparent" || k == "tracestate") {
+			carrier[k] = s
+		} else {
+			onlyTrace = false
+		}
+	}
+	if onlyTrace {
+		msg.SocketEvent.Args = args[:len(args)-1]
+		if len(msg.SocketEvent.Args) == 0 {
+			msg.SocketEvent.EventContent = nil
+		}
+	}
+	return carrier, true
+}
+
+func eventAttributes(msg *protocol.Message) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "socket.io"),
+		attribute.String("messaging.destination.name", "/"+msg.SocketEvent.NS),
+		attribute.String("socketio.event", msg.SocketEvent.EventName),
+	}
+}
+
+func endFunc(span trace.Span) func(err error) {
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
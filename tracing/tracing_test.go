@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+func remoteContext() context.Context {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  interface{}
+		carrier  Carrier
+		wantArgs int
+	}{
+		{"argument", map[string]interface{}{"text": "hi"}, CarrierArgument, 1},
+		{"empty content", "", CarrierArgument, 0},
+		{"payload", map[string]interface{}{"text": "hi"}, CarrierPayload, 1},
+		{"payload fallback", "hi", CarrierPayload, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := New(noop.NewTracerProvider(), WithCarrier(tt.carrier))
+			ctx := remoteContext()
+
+			msg := &protocol.Message{
+				EngineIoType: protocol.EngineMessageTypeMessage,
+				SocketType:   protocol.SocketMessageTypeEvent,
+				SocketEvent:  protocol.SocketEvent{EventName: "say", EventContent: tt.content},
+			}
+			_, end := tracer.StartEmit(ctx, msg)
+			end(nil)
+
+			data, err := protocol.Encode(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received, err := protocol.GetSocketIoMessage(data)
+			if err != nil {
+				t.Fatalf("decode %s: %v", data, err)
+			}
+			eventCtx, end := tracer.StartEvent(context.Background(), received)
+			end(nil)
+
+			got := trace.SpanContextFromContext(eventCtx)
+			if got.TraceID() != trace.SpanContextFromContext(ctx).TraceID() {
+				t.Fatalf("trace id = %s from %s", got.TraceID(), data)
+			}
+			if len(received.SocketEvent.Args) != tt.wantArgs {
+				t.Fatalf("handler args = %d, want %d in %s", len(received.SocketEvent.Args), tt.wantArgs, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+/*
+*
+Frame to send with WriteFrames
+*/
+type Frame struct {
+	Data   []byte
+	Binary bool
+}
+
+/*
+*
+Frames of a WriteFrames call that did not reach the network connection, in
+order. Err is ErrorReconnecting when the transport reconnects, the frames
+can be sent again on the next connection
+*/
+type UnsentFramesError struct {
+	Frames []Frame
+	Err    error
+}
+
+func (e *UnsentFramesError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnsentFramesError) Unwrap() error {
+	return e.Err
+}
+
+/*
+*
+Network connection holding writes back while a batch is open, so a batch of
+websocket messages leaves in as few writes as possible. Everything written
+during a batch is held back, control frames gorilla writes meanwhile too.
+Outside of a batch, e.g. for the TLS handshake, writes go straight through
+*/
+type batchConn struct {
+	net.Conn
+	lock     sync.Mutex
+	buf      []byte
+	limit    int
+	batching bool
+	// successful flushes of a non empty buffer
+	flushes int
+}
+
+func (bc *batchConn) Write(b []byte) (int, error) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if !bc.batching {
+		return bc.Conn.Write(b)
+	}
+	if len(bc.buf)+len(b) > bc.limit {
+		if err := bc.flushLocked(); err != nil {
+			return 0, err
+		}
+	}
+	bc.buf = append(bc.buf, b...)
+	return len(b), nil
+}
+
+func (bc *batchConn) begin() {
+	bc.lock.Lock()
+	bc.batching = true
+	bc.lock.Unlock()
+}
+
+/*
+*
+Close the batch and write what it holds
+*/
+func (bc *batchConn) end() error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.batching = false
+	return bc.flushLocked()
+}
+
+/*
+*
+Close the batch dropping what it holds, after a failed write
+*/
+func (bc *batchConn) abort() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	bc.batching = false
+	bc.buf = bc.buf[:0]
+}
+
+func (bc *batchConn) flushCount() int {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	return bc.flushes
+}
+
+func (bc *batchConn) flushLocked() error {
+	if len(bc.buf) == 0 {
+		return nil
+	}
+	_, err := bc.Conn.Write(bc.buf)
+	bc.buf = bc.buf[:0]
+	if err == nil {
+		bc.flushes++
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+/*
+*
+Network connection recording its writes, writes fail once fail is set.
+Without Conn writes only go to the record
+*/
+type recordConn struct {
+	net.Conn
+	lock   sync.Mutex
+	writes [][]byte
+	fail   error
+}
+
+func (rc *recordConn) Write(b []byte) (int, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if rc.fail != nil {
+		return 0, rc.fail
+	}
+	rc.writes = append(rc.writes, append([]byte(nil), b...))
+	if rc.Conn == nil {
+		return len(b), nil
+	}
+	return rc.Conn.Write(b)
+}
+
+func (rc *recordConn) writeCount() int {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return len(rc.writes)
+}
+
+func (rc *recordConn) setFail(err error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.fail = err
+}
+
+func TestBatchConn(t *testing.T) {
+	rc := &recordConn{}
+	bc := &batchConn{Conn: rc, limit: 8}
+
+	bc.Write([]byte("direct"))
+	if rc.writeCount() != 1 {
+		t.Fatal("write outside of a batch held back")
+	}
+
+	bc.begin()
+	bc.Write([]byte("ab"))
+	bc.Write([]byte("cd"))
+	if rc.writeCount() != 1 {
+		t.Fatal("write inside a batch went through")
+	}
+	// over the limit the held back writes leave first
+	bc.Write([]byte("efghi"))
+	if rc.writeCount() != 2 || string(rc.writes[1]) != "abcd" || bc.flushCount() != 1 {
+		t.Fatalf("writes = %q", rc.writes)
+	}
+	if err := bc.end(); err != nil {
+		t.Fatal(err)
+	}
+	if rc.writeCount() != 3 || string(rc.writes[2]) != "efghi" || bc.flushCount() != 2 {
+		t.Fatalf("writes = %q", rc.writes)
+	}
+
+	bc.begin()
+	bc.Write([]byte("lost"))
+	bc.abort()
+	if err := bc.end(); err != nil || rc.writeCount() != 3 {
+		t.Fatalf("aborted batch written: %q", rc.writes)
+	}
+}
+
+/*
+*
+Transport dialing through a recordConn
+*/
+func recordingTransport(rc **recordConn) *WebsocketTransport {
+	wst := newTestTransport()
+	wst.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		*rc = &recordConn{Conn: conn}
+		return *rc, nil
+	}
+	return wst
+}
+
+func TestWriteFramesBatched(t *testing.T) {
+	s := newPollServer(t)
+	var rc *recordConn
+	conn, err := recordingTransport(&rc).Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frames := []Frame{{Data: []byte("42[\"a\"]")}, {Data: []byte{0x81, 0xa1, 'b'}, Binary: true}, {Data: []byte("42[\"c\"]")}}
+	before := rc.writeCount()
+	if err := conn.(BatchConnection).WriteFrames(frames); err != nil {
+		t.Fatal(err)
+	}
+	if n := rc.writeCount() - before; n != 1 {
+		t.Fatalf("batch of %d frames took %d writes", len(frames), n)
+	}
+	for _, frame := range frames {
+		data, binary, err := GetFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(frame.Data) || binary != frame.Binary {
+			t.Fatalf("echo %q binary %v, want %q binary %v", data, binary, frame.Data, frame.Binary)
+		}
+	}
+}
+
+func TestWriteFramesReturnsUnsent(t *testing.T) {
+	s := newPollServer(t)
+	var rc *recordConn
+	conn, err := recordingTransport(&rc).Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rc.setFail(errors.New("connection reset"))
+	frames := []Frame{{Data: []byte("1")}, {Data: []byte("2")}, {Data: []byte("3")}}
+	err = conn.(BatchConnection).WriteFrames(frames)
+	var unsent *UnsentFramesError
+	if !errors.As(err, &unsent) || !errors.Is(err, ErrorReconnecting) {
+		t.Fatalf("WriteFrames = %v, want UnsentFramesError of ErrorReconnecting", err)
+	}
+	if len(unsent.Frames) != len(frames) {
+		t.Fatalf("%d unsent frames, want %d", len(unsent.Frames), len(frames))
+	}
+	// reconnecting, nothing more is written
+	if err := conn.WriteMessage("4"); err != ErrorReconnecting {
+		t.Fatalf("WriteMessage while reconnecting = %v", err)
+	}
+}
+
+func TestWriteFramesReturnsUnsentAfterFlush(t *testing.T) {
+	s := newPollServer(t)
+	var rc *recordConn
+	wst := recordingTransport(&rc)
+	wst.BufferSize = 16
+	conn, err := wst.Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the first frames fill the batch buffer and are flushed while the
+	// next ones are written, the connection fails afterwards
+	frames := []Frame{{Data: []byte("0123456789")}, {Data: []byte("abcdefghij")}, {Data: []byte("klmnopqrst")}}
+	flushed := &failAfter{recordConn: rc, writes: 1}
+	conn.(*WebsocketConnection).batch.Conn = flushed
+	err = conn.(BatchConnection).WriteFrames(frames)
+	var unsent *UnsentFramesError
+	if !errors.As(err, &unsent) {
+		t.Fatalf("WriteFrames = %v, want UnsentFramesError", err)
+	}
+	if len(unsent.Frames) != 2 || string(unsent.Frames[0].Data) != "abcdefghij" {
+		t.Fatalf("unsent = %v, want the frames after the flushed one", unsent.Frames)
+	}
+}
+
+/*
+*
+recordConn failing after the given number of writes
+*/
+type failAfter struct {
+	*recordConn
+	writes int
+}
+
+func (fa *failAfter) Write(b []byte) (int, error) {
+	if fa.writes == 0 {
+		return 0, errors.New("connection reset")
+	}
+	fa.writes--
+	return fa.recordConn.Write(b)
+}
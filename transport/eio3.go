@@ -0,0 +1,138 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+/*
+*
+Engine.IO protocol version requested by the EIO query parameter of rawURL
+*/
+func engineIOVersion(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("EIO")
+}
+
+/*
+*
+Query of the handshake url without the Engine.IO parameters, sent with the
+Socket.IO v2 CONNECT packet
+*/
+func connectQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	for _, key := range []string{"EIO", "transport", "t", "sid"} {
+		query.Del(key)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+/*
+*
+Send one polling request and return the response body
+*/
+func (wst *WebsocketTransport) poll(ctx context.Context, client *http.Client, method string, rawURL string, body io.Reader) (string, error) {
+	req, err := wst.newRequest(ctx, method, rawURL, body)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("polling request failed: " + resp.Status + " " + string(data))
+	}
+	return string(data), nil
+}
+
+/*
+*
+Engine.IO v3 / Socket.IO v2 handshake. Payloads are length prefixed, the
+root namespace is connected by the server, other namespaces are connected
+with the query in the CONNECT packet and without auth payload
+*/
+func (wst *WebsocketTransport) handshakeV3(ctx context.Context, client *http.Client, handshakeURL string, namespace string, params ConnParams) (string, error) {
+	body, err := wst.poll(ctx, client, "GET", handshakeURL, nil)
+	if err != nil {
+		return "", err
+	}
+	packets, err := protocol.DecodePayloadV3(body)
+	if err != nil || len(packets) == 0 || !strings.HasPrefix(packets[0], "0") {
+		return "", errors.New("failed to get sid from handshake response: " + body)
+	}
+	var open struct {
+		Sid string `json:"sid"`
+	}
+	if err := json.Unmarshal([]byte(packets[0][1:]), &open); err != nil {
+		return "", err
+	}
+	if open.Sid == "" {
+		return "", errors.New("failed to get sid from handshake response")
+	}
+	if params.OnOpen != nil {
+		params.OnOpen(packets[0][1:])
+	}
+	sidURL := handshakeURL + "&sid=" + open.Sid
+
+	nsp := ""
+	if namespace != "" {
+		nsp = "/" + namespace
+		connect := protocol.EncodePayloadV3("40" + nsp + connectQuery(handshakeURL) + ",")
+		if _, err := wst.poll(ctx, client, "POST", sidURL, strings.NewReader(connect)); err != nil {
+			return "", err
+		}
+	} else if connected(packets[1:], nsp) {
+		return open.Sid, nil
+	}
+
+	body, err = wst.poll(ctx, client, "GET", sidURL, nil)
+	if err != nil {
+		return "", err
+	}
+	packets, err = protocol.DecodePayloadV3(body)
+	if err != nil || !connected(packets, nsp) {
+		return "", errors.New("failed to handshake, check your auth or namespace:" + body)
+	}
+	return open.Sid, nil
+}
+
+/*
+*
+Whether packets contain the CONNECT of namespace nsp, or an event for it
+*/
+func connected(packets []string, nsp string) bool {
+	for _, packet := range packets {
+		for _, prefix := range []string{"40", "42"} {
+			if nsp == "" && (packet == prefix || strings.HasPrefix(packet, prefix+"[")) {
+				return true
+			}
+			if nsp != "" && strings.HasPrefix(packet, prefix+nsp) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
+)
+
+const openV3 = `0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`
+
+/*
+*
+Engine.IO v3 polling server, the root namespace is connected with the open
+packet, other namespaces by the CONNECT of a POST
+*/
+func newPollServerV3(t *testing.T) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var posts []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			body, _ := io.ReadAll(r.Body)
+			lock.Lock()
+			posts = append(posts, string(body))
+			lock.Unlock()
+			w.Write([]byte("ok"))
+		case r.URL.Query().Get("sid") == "":
+			w.Write([]byte(protocol.EncodePayloadV3(openV3, "40")))
+		default:
+			w.Write([]byte(protocol.EncodePayloadV3("40/chat", `42/chat,["welcome"]`)))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), posts...)
+	}
+}
+
+func TestHandshakeV3(t *testing.T) {
+	s, posts := newPollServerV3(t)
+	var open string
+	params := ConnParams{OnOpen: func(header string) { open = header }}
+
+	sid, err := newTestTransport().HandshakeContext(context.Background(), s.URL+"/socket.io/?EIO=3&transport=polling", "", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "abc" {
+		t.Fatalf("sid = %q", sid)
+	}
+	if open != openV3[1:] {
+		t.Fatalf("open header = %q", open)
+	}
+	if len(posts()) != 0 {
+		t.Fatalf("root namespace posted %q", posts())
+	}
+}
+
+func TestHandshakeV3Namespace(t *testing.T) {
+	s, posts := newPollServerV3(t)
+	url := s.URL + "/socket.io/?EIO=3&transport=polling&token=abc"
+
+	sid, err := newTestTransport().Handshake(url, "chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != "abc" {
+		t.Fatalf("sid = %q", sid)
+	}
+	want := protocol.EncodePayloadV3("40/chat?token=abc,")
+	if got := posts(); len(got) != 1 || got[0] != want {
+		t.Fatalf("posted %q, want %q", got, want)
+	}
+
+	if _, err := newTestTransport().Handshake(url, "other"); err == nil {
+		t.Fatal("handshake of a namespace the server did not connect succeeded")
+	}
+}
+
+func TestHandshakeV3MalformedPayload(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("99:" + openV3))
+	}))
+	defer s.Close()
+	if _, err := newTestTransport().Handshake(s.URL+"/socket.io/?EIO=3&transport=polling", ""); err == nil {
+		t.Fatal("handshake with a malformed payload succeeded")
+	}
+}
+
+func TestSendReconnectSignalOnce(t *testing.T) {
+	wsc := newWebsocketConnection(nil, GetDefaultWebsocketTransport(), nil, nil)
+	if !wsc.SendReconnectSignal() {
+		t.Fatal("first signal refused")
+	}
+	// the failing read and write of a lost connection both signal it
+	if !wsc.SendReconnectSignal() {
+		t.Fatal("second signal refused")
+	}
+	select {
+	case <-wsc.ReConnectChan():
+	default:
+		t.Fatal("lost connection not signalled")
+	}
+}
+
+func TestConnectionStatusNotShared(t *testing.T) {
+	wst := GetDefaultWebsocketTransport()
+	closed := newWebsocketConnection(nil, wst, nil, nil)
+	closed.status.Store(StatusClosed)
+	if closed.SendReconnectSignal() {
+		t.Fatal("connection closed on purpose signalled a reconnect")
+	}
+	sibling := newWebsocketConnection(nil, wst, nil, nil)
+	if sibling.Status() != StatusConnected || !sibling.SendReconnectSignal() {
+		t.Fatalf("sibling of a closed connection has status %d", sibling.Status())
+	}
+	if sibling.Status() != StatusReconnecting {
+		t.Fatalf("lost connection has status %d", sibling.Status())
+	}
+	select {
+	case <-closed.ReConnectChan():
+		t.Fatal("sibling loss signalled on the closed connection")
+	default:
+	}
+}
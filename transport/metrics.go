@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"net"
+)
+
+const (
+	// message bytes before compression
+	MetricPayloadBytes = "socketio_websocket_payload_bytes_total"
+	// raw bytes read from or written to the websocket network connection,
+	// including the upgrade request, the TLS handshake and records and the
+	// websocket frame headers. Not the compressed size of the messages alone,
+	// compare it with MetricPayloadBytes over many messages
+	MetricSocketBytes = "socketio_websocket_socket_bytes_total"
+)
+
+var (
+	sentLabels     = map[string]string{"direction": "sent"}
+	receivedLabels = map[string]string{"direction": "received"}
+)
+
+/*
+*
+Metrics receives transport counters, socketioclient.Metrics satisfies it
+*/
+type Metrics interface {
+	AddCounter(name string, delta float64, labels map[string]string)
+}
+
+/*
+*
+Network connection counting the bytes going through it
+*/
+type countingConn struct {
+	net.Conn
+	metrics Metrics
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	if n > 0 {
+		cc.metrics.AddCounter(MetricSocketBytes, float64(n), receivedLabels)
+	}
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	if n > 0 {
+		cc.metrics.AddCounter(MetricSocketBytes, float64(n), sentLabels)
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+)
+
+var ErrorCertificatePinMismatch = errors.New("no certificate matches the pinned public keys")
+
+/*
+*
+Returns a tls.Config.VerifyConnection function accepting the connection only
+if a certificate of the verified chain has one of the given public key pins.
+Without chain verification (InsecureSkipVerify) only the leaf certificate is
+checked, the rest of the presented chain is up to the peer.
+Pins are base64 encoded SHA-256 hashes of the DER SubjectPublicKeyInfo, as
+printed by
+
+	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+*/
+func VerifySPKIPins(pins ...string) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) > 0 {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if pinned(cert, pins) {
+						return nil
+					}
+				}
+			}
+			return ErrorCertificatePinMismatch
+		}
+		if len(cs.PeerCertificates) > 0 && pinned(cs.PeerCertificates[0], pins) {
+			return nil
+		}
+		return ErrorCertificatePinMismatch
+	}
+}
+
+func pinned(cert *x509.Certificate, pins []string) bool {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	for _, expected := range pins {
+		if pin == expected {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+*
+TLS config for the handshake and the websocket dialer: a clone of TLSConfig,
+with UnsecureTLS and PinnedSPKI applied
+*/
+func (wst *WebsocketTransport) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if wst.TLSConfig != nil {
+		config = wst.TLSConfig.Clone()
+	}
+	if wst.UnsecureTLS {
+		config.InsecureSkipVerify = true
+	}
+	if len(wst.PinnedSPKI) > 0 {
+		verifyPins := VerifySPKIPins(wst.PinnedSPKI...)
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			return verifyPins(cs)
+		}
+	}
+	return config
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifySPKIPins(t *testing.T) {
+	leaf := newTestCert(t, "leaf")
+	root := newTestCert(t, "root")
+	pinnedCert := newTestCert(t, "pinned")
+
+	tests := []struct {
+		name string
+		cs   tls.ConnectionState
+		pin  string
+		ok   bool
+	}{
+		{"matching leaf", tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}, spkiPin(leaf), true},
+		{"mismatched leaf", tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}, spkiPin(root), false},
+		{
+			"pinned cert appended to a foreign chain",
+			tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, pinnedCert}},
+			spkiPin(pinnedCert),
+			false,
+		},
+		{
+			"pinned root of the verified chain",
+			tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{leaf},
+				VerifiedChains:   [][]*x509.Certificate{{leaf, root}},
+			},
+			spkiPin(root),
+			true,
+		},
+		{
+			"presented cert outside the verified chain",
+			tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{leaf, pinnedCert},
+				VerifiedChains:   [][]*x509.Certificate{{leaf, root}},
+			},
+			spkiPin(pinnedCert),
+			false,
+		},
+		{"no certificate", tls.ConnectionState{}, spkiPin(leaf), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySPKIPins(test.pin)(test.cs)
+			if test.ok && err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if !test.ok && err != ErrorCertificatePinMismatch {
+				t.Fatalf("got %v, want %v", err, ErrorCertificatePinMismatch)
+			}
+		})
+	}
+}
+
+func TestPinnedSPKIHandshake(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc((&pollServer{}).serve))
+	defer s.Close()
+	pin := spkiPin(s.Certificate())
+
+	wst := newTestTransport()
+	wst.UnsecureTLS = true
+	wst.PinnedSPKI = []string{pin}
+	if _, err := wst.Handshake(s.URL+"/socket.io/?EIO=4&transport=polling", "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	wst = newTestTransport()
+	wst.UnsecureTLS = true
+	wst.PinnedSPKI = []string{spkiPin(newTestCert(t, "other"))}
+	if _, err := wst.Handshake(s.URL+"/socket.io/?EIO=4&transport=polling", "/"); err == nil {
+		t.Fatal("handshake with a mismatched pin succeeded")
+	}
+}
@@ -1,8 +1,11 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 	"time"
+
+	"github.com/liangsqrt/socketio-client-go/protocol"
 )
 
 /*
@@ -31,6 +34,96 @@ type Connection interface {
 	PingParams() (interval, timeout time.Duration)
 }
 
+/*
+*
+Optional Connection methods for binary frames, used by parsers like
+protocol.MsgpackParser. Connections without them exchange text messages only
+*/
+type FrameConnection interface {
+	/**
+	Receive one more frame, binary tells whether it was a binary frame
+	*/
+	GetFrame() (data []byte, binary bool, err error)
+
+	/**
+	Send given frame as a binary or text frame
+	*/
+	WriteFrame(data []byte, binary bool) error
+}
+
+/*
+*
+Optional Connection method sending several frames at once
+*/
+type BatchConnection interface {
+	/**
+	Send given frames in order, flushing them to the network together. On
+	error the frames not sent are returned in an UnsentFramesError
+	*/
+	WriteFrames(frames []Frame) error
+}
+
+/*
+*
+Optional Connection method telling when the connection is lost, connections
+without it are never replaced
+*/
+type ReconnectConnection interface {
+	/**
+	Closed once the connection is lost and a new one must be made
+	*/
+	ReConnectChan() <-chan struct{}
+}
+
+/*
+*
+Receive one frame from conn, through GetMessage if it does not implement
+FrameConnection. An empty message, e.g. while the transport reconnects,
+gives nil data
+*/
+func GetFrame(conn Connection) (data []byte, binary bool, err error) {
+	if fc, ok := conn.(FrameConnection); ok {
+		return fc.GetFrame()
+	}
+	message, err := conn.GetMessage()
+	if err != nil || message == "" {
+		return nil, false, err
+	}
+	return []byte(message), false, nil
+}
+
+/*
+*
+Send frame on conn, binary frames need a FrameConnection
+*/
+func WriteFrame(conn Connection, data []byte, binary bool) error {
+	if fc, ok := conn.(FrameConnection); ok {
+		return fc.WriteFrame(data, binary)
+	}
+	if binary {
+		return ErrorBinaryMessage
+	}
+	return conn.WriteMessage(string(data))
+}
+
+/*
+*
+Send frames on conn in order, one by one if it does not implement
+BatchConnection. On error the frames not sent are returned in an
+UnsentFramesError
+*/
+func WriteFrames(conn Connection, frames []Frame) error {
+	if bc, ok := conn.(BatchConnection); ok {
+		return bc.WriteFrames(frames)
+	}
+	for i, frame := range frames {
+		if err := WriteFrame(conn, frame.Data, frame.Binary); err != nil {
+			return &UnsentFramesError{Frames: frames[i:], Err: err}
+		}
+	}
+	return nil
+}
+
 /*
 *
 Connection factory for given transport
@@ -56,3 +149,38 @@ type Transport interface {
 	*/
 	Serve(w http.ResponseWriter, r *http.Request)
 }
+
+/*
+*
+Settings of one client connection. Transports are shared between clients,
+so these are passed with every handshake and dial instead of being set on
+the transport. Nil fields fall back to the transport defaults
+*/
+type ConnParams struct {
+	// payload of the namespace CONNECT packet, marshalled to JSON
+	Auth interface{}
+	// socket.io packet encoding of the handshake
+	Parser protocol.Parser
+	// sink for payload and socket byte counters
+	Metrics Metrics
+	// called with the JSON of the Engine.IO open packet of the handshake,
+	// e.g. {"sid":"...","pingInterval":25000,"pingTimeout":20000}
+	OnOpen func(header string)
+}
+
+/*
+*
+Optional Transport methods aborting the handshake and the dial when ctx is
+done, used by the client when the transport implements them
+*/
+type ContextTransport interface {
+	/**
+	Handshake with server, abort when ctx is done
+	*/
+	HandshakeContext(ctx context.Context, url string, namespace string, params ConnParams) (sid string, err error)
+
+	/**
+	Get client connection, abort dialing when ctx is done
+	*/
+	ConnectContext(ctx context.Context, url string, params ConnParams) (conn Connection, err error)
+}
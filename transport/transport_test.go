@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+/*
+*
+Connection exchanging text messages only
+*/
+type textConn struct {
+	read    []string
+	written []string
+	// writes failing from then on, -1 for none
+	limit int
+}
+
+func (c *textConn) GetMessage() (string, error) {
+	message := c.read[0]
+	c.read = c.read[1:]
+	return message, nil
+}
+
+func (c *textConn) WriteMessage(message string) error {
+	if len(c.written) == c.limit {
+		return ErrorReconnecting
+	}
+	c.written = append(c.written, message)
+	return nil
+}
+
+func (c *textConn) Close() {}
+
+func (c *textConn) PingParams() (time.Duration, time.Duration) { return time.Second, time.Second }
+
+func TestFramesOfTextConnection(t *testing.T) {
+	conn := &textConn{read: []string{"40", ""}, limit: -1}
+	data, binary, err := GetFrame(conn)
+	if err != nil || binary || string(data) != "40" {
+		t.Fatalf("GetFrame = %q, %v, %v", data, binary, err)
+	}
+	// an empty message means the transport is reconnecting
+	if data, _, err := GetFrame(conn); err != nil || data != nil {
+		t.Fatalf("GetFrame of an empty message = %q, %v", data, err)
+	}
+
+	if err := WriteFrame(conn, []byte("42"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFrame(conn, []byte{0x80}, true); err != ErrorBinaryMessage {
+		t.Fatalf("binary WriteFrame = %v, want ErrorBinaryMessage", err)
+	}
+	if len(conn.written) != 1 || conn.written[0] != "42" {
+		t.Fatalf("written = %q", conn.written)
+	}
+}
+
+func TestWriteFramesOfTextConnection(t *testing.T) {
+	conn := &textConn{limit: 2}
+	frames := []Frame{{Data: []byte("1")}, {Data: []byte("2")}, {Data: []byte("3")}, {Data: []byte("4")}}
+	err := WriteFrames(conn, frames)
+	var unsent *UnsentFramesError
+	if !errors.As(err, &unsent) || !errors.Is(err, ErrorReconnecting) {
+		t.Fatalf("WriteFrames = %v, want UnsentFramesError of ErrorReconnecting", err)
+	}
+	if len(unsent.Frames) != 2 || string(unsent.Frames[0].Data) != "3" {
+		t.Fatalf("unsent = %v", unsent.Frames)
+	}
+	if len(conn.written) != 2 {
+		t.Fatalf("written = %q", conn.written)
+	}
+}
@@ -0,0 +1,494 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+*
+Engine.IO v4 server answering the polling handshake and echoing websocket
+messages, it records the requests it got
+*/
+type pollServer struct {
+	*httptest.Server
+	upgrader websocket.Upgrader
+
+	lock     sync.Mutex
+	requests []*http.Request
+}
+
+func newPollServer(t *testing.T) *pollServer {
+	s := &pollServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pollServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r)
+	s.lock.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case query.Get("transport") == "websocket":
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(msgType, data)
+		}
+	case r.Method == "POST":
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "connected"})
+		w.Write([]byte("ok"))
+	case query.Get("sid") == "":
+		http.SetCookie(w, &http.Cookie{Name: "io", Value: "sid1"})
+		w.Write([]byte(`0{"sid":"sid1","pingInterval":25000,"pingTimeout":20000}`))
+	default:
+		w.Write([]byte("40"))
+	}
+}
+
+func (s *pollServer) allRequests() []*http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *pollServer) pollingURL() string {
+	return s.URL + "/socket.io/?EIO=4&transport=polling"
+}
+
+func (s *pollServer) websocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/socket.io/?EIO=4&transport=websocket"
+}
+
+func newTestTransport() *WebsocketTransport {
+	return &WebsocketTransport{
+		PingInterval:   WsDefaultPingInterval,
+		PingTimeout:    WsDefaultPingTimeout,
+		ReceiveTimeout: time.Second,
+		SendTimeout:    time.Second,
+		BufferSize:     WsDefaultBufferSize,
+		// keep the environment proxy out of the tests
+		Proxy: func(*http.Request) (*url.URL, error) { return nil, nil },
+	}
+}
+
+func TestHeadersOnEveryRequest(t *testing.T) {
+	s := newPollServer(t)
+	wst := newTestTransport()
+	wst.RequestHeader = http.Header{"Authorization": {"Bearer token"}}
+	wst.ExtraHeaders = http.Header{"X-Extra": {"extra"}}
+
+	if _, err := wst.Handshake(s.pollingURL(), "/"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wst.Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requests := s.allRequests()
+	if len(requests) != 4 {
+		t.Fatalf("got %d requests, want 4", len(requests))
+	}
+	for i, r := range requests {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("request %d: Authorization = %q", i, got)
+		}
+		if got := r.Header.Get("X-Extra"); got != "extra" {
+			t.Errorf("request %d: X-Extra = %q", i, got)
+		}
+	}
+}
+
+func TestDefaultClientKeepsCookies(t *testing.T) {
+	s := newPollServer(t)
+	wst := newTestTransport()
+
+	if _, err := wst.Handshake(s.pollingURL(), "/"); err != nil {
+		t.Fatal(err)
+	}
+	if wst.httpClient() != wst.httpClient() {
+		t.Fatal("default client is not reused")
+	}
+	conn, err := wst.Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requests := s.allRequests()
+	if len(requests) != 4 {
+		t.Fatalf("got %d requests, want 4", len(requests))
+	}
+	if _, err := requests[1].Cookie("io"); err != nil {
+		t.Error("CONNECT request without the handshake cookie")
+	}
+	upgrade := requests[3]
+	for _, name := range []string{"io", "session"} {
+		if _, err := upgrade.Cookie(name); err != nil {
+			t.Errorf("upgrade request without cookie %q", name)
+		}
+	}
+}
+
+/*
+*
+Forward proxy answering absolute-form requests and CONNECT tunnels, it
+records the requested hosts
+*/
+type testProxy struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	hosts []string
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	p := &testProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProxy) serve(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	p.hosts = append(p.hosts, r.Method+" "+r.Host)
+	p.lock.Unlock()
+
+	if r.Method == http.MethodConnect {
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+		return
+	}
+	r.RequestURI = ""
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *testProxy) requested() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+/*
+*
+SOCKS5 proxy with username/password authentication, CONNECT only
+*/
+func newSOCKS5Proxy(t *testing.T, user, password string) (addr string, dialed chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	dialed = make(chan string, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, password, dialed)
+		}
+	}()
+	return l.Addr().String(), dialed
+}
+
+func serveSOCKS5(conn net.Conn, user, password string, dialed chan string) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	// greeting: version, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	// RFC 1929 username/password
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	gotUser := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, gotUser); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return
+	}
+	gotPassword := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, gotPassword); err != nil {
+		return
+	}
+	if string(gotUser) != user || string(gotPassword) != password {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+	// request: version, CONNECT, reserved, address type
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(buf[0])<<8|int(buf[1])))
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	dialed <- target
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestHTTPProxy(t *testing.T) {
+	s := newPollServer(t)
+	proxy := newTestProxy(t)
+	wst := newTestTransport()
+	if err := wst.SetProxyURL(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wst.Handshake(s.pollingURL(), "/"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wst.Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	host := s.Listener.Addr().String()
+	want := []string{"GET " + host, "POST " + host, "GET " + host, "CONNECT " + host}
+	got := proxy.requested()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("proxy got %v, want %v", got, want)
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	s := newPollServer(t)
+	addr, dialed := newSOCKS5Proxy(t, "user", "secret")
+	wst := newTestTransport()
+	if err := wst.SetProxyURL("socks5://user:secret@" + addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wst.Handshake(s.pollingURL(), "/"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wst.Connect(s.websocketURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage("2probe"); err != nil {
+		t.Fatal(err)
+	}
+	if message, err := conn.GetMessage(); err != nil || message != "2probe" {
+		t.Fatalf("got %q, %v through the proxy", message, err)
+	}
+	if len(dialed) < 2 {
+		t.Fatalf("proxy dialed %d times, want the handshake and the websocket", len(dialed))
+	}
+	if target := <-dialed; target != s.Listener.Addr().String() {
+		t.Fatalf("proxy dialed %s", target)
+	}
+}
+
+func TestSOCKS5ProxyWrongPassword(t *testing.T) {
+	s := newPollServer(t)
+	addr, _ := newSOCKS5Proxy(t, "user", "secret")
+	wst := newTestTransport()
+	if err := wst.SetProxyURL("socks5://user:wrong@" + addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wst.Connect(s.websocketURL()); err == nil {
+		t.Fatal("websocket connected with a wrong proxy password")
+	}
+}
+
+func TestUnixSocketDialer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socketio.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &pollServer{}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	s.Listener.Close()
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	wst := newTestTransport()
+	wst.DialContext = UnixSocketDialer(path)
+	if _, err := wst.Handshake("http://localhost/socket.io/?EIO=4&transport=polling", "/"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wst.Connect("ws://localhost/socket.io/?EIO=4&transport=websocket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(s.allRequests()) != 4 {
+		t.Fatalf("got %d requests over the socket, want 4", len(s.allRequests()))
+	}
+}
+
+type counterMetrics struct {
+	lock     sync.Mutex
+	counters map[string]float64
+}
+
+func (m *counterMetrics) AddCounter(name string, delta float64, labels map[string]string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters == nil {
+		m.counters = map[string]float64{}
+	}
+	m.counters[name+" "+labels["direction"]] += delta
+}
+
+func (m *counterMetrics) get(name, direction string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counters[name+" "+direction]
+}
+
+/*
+*
+Send message on a fresh connection and return the counted payload and socket
+bytes of the write
+*/
+func compressedWrite(t *testing.T, wst *WebsocketTransport, s *pollServer, message string) (payload, socket float64) {
+	metrics := &counterMetrics{}
+	conn, err := wst.ConnectContext(context.Background(), s.websocketURL(), ConnParams{Metrics: metrics})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	before := metrics.get(MetricSocketBytes, "sent")
+	if err := conn.WriteMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	if echo, err := conn.GetMessage(); err != nil || echo != message {
+		t.Fatalf("echo of %d bytes: got %d bytes, %v", len(message), len(echo), err)
+	}
+	return metrics.get(MetricPayloadBytes, "sent"), metrics.get(MetricSocketBytes, "sent") - before
+}
+
+func TestCompression(t *testing.T) {
+	s := newPollServer(t)
+	s.upgrader.EnableCompression = true
+	message := `42["event","` + strings.Repeat("compressible ", 1000) + `"]`
+
+	wst := newTestTransport()
+	wst.EnableCompression = true
+	payload, socket := compressedWrite(t, wst, s, message)
+	if payload != float64(len(message)) {
+		t.Fatalf("payload bytes %v, want %d", payload, len(message))
+	}
+	if socket >= payload/10 {
+		t.Fatalf("sent %v socket bytes for %v payload bytes, message not compressed", socket, payload)
+	}
+
+	wst.CompressionLevel = 9
+	if _, socket := compressedWrite(t, wst, s, message); socket >= payload/10 {
+		t.Fatalf("sent %v socket bytes at level 9, message not compressed", socket)
+	}
+
+	wst.CompressionThreshold = len(message) + 1
+	if _, socket := compressedWrite(t, wst, s, message); socket < payload {
+		t.Fatalf("sent %v socket bytes for %v payload bytes below the threshold", socket, payload)
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	s := newPollServer(t)
+	s.upgrader.EnableCompression = true
+	message := strings.Repeat("compressible ", 1000)
+
+	payload, socket := compressedWrite(t, newTestTransport(), s, message)
+	if socket < payload {
+		t.Fatalf("sent %v socket bytes for %v payload bytes without compression", socket, payload)
+	}
+}
+
+func TestCompressionLevelInvalid(t *testing.T) {
+	s := newPollServer(t)
+	s.upgrader.EnableCompression = true
+	wst := newTestTransport()
+	wst.EnableCompression = true
+	wst.CompressionLevel = 42
+	if _, err := wst.Connect(s.websocketURL()); err == nil {
+		t.Fatal("connected with an invalid compression level")
+	}
+}
@@ -0,0 +1,57 @@
+package socketioclient
+
+import "context"
+
+/*
+*
+Emitter of packets that may be lost, like socket.volatile of the JS client.
+Packets are dropped instead of queued while the connection is down or
+reconnecting, or when the outbound queue holds more than
+ConConf.VolatileThreshold packets. Dropped packets are not an error, they
+are counted in socketio_volatile_dropped_total
+*/
+type Volatile struct {
+	client *Client
+}
+
+/*
+*
+Volatile emitter of the client, for events that are useless when late
+
+	c.Volatile().Emit("cursor", position)
+*/
+func (c *Client) Volatile() *Volatile {
+	return &Volatile{client: c}
+}
+
+func (v *Volatile) Emit(method string, args interface{}) error {
+	return v.EmitContext(context.Background(), method, args)
+}
+
+/*
+*
+Emit with ctx as parent of the emit span
+*/
+func (v *Volatile) EmitContext(ctx context.Context, method string, args interface{}) error {
+	c := v.client
+	if !c.writable() {
+		c.stats().AddCounter(MetricVolatileDropped, 1, eventLabels(method))
+		return nil
+	}
+	return c.emit(ctx, method, args, 0, queueVolatile, false)
+}
+
+/*
+*
+Whether the connection is up and the queue below the volatile threshold
+*/
+func (c *Client) writable() bool {
+	if !c.IsAlive() {
+		return false
+	}
+	// the lost connection of this client, others sharing the transport may be fine
+	if connectionLost(c.connection()) {
+		return false
+	}
+	return c.QueueLen() <= c.volatileThreshold
+}
@@ -0,0 +1,64 @@
+package socketioclient
+
+import "testing"
+
+func TestVolatileEmitQueuedWhenWritable(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{Metrics: metrics})
+	if err := c.Volatile().Emit("cursor", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := queued(c); len(got) != 1 {
+		t.Fatalf("queued %q, want the volatile emit", got)
+	}
+	if n := metrics.counter(MetricVolatileDropped); n != 0 {
+		t.Fatalf("volatile dropped = %v, want 0", n)
+	}
+}
+
+func TestVolatileEmitDroppedWhileDisconnected(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{Metrics: metrics})
+	c.setAliveValue(false)
+	if err := c.Volatile().Emit("cursor", 1); err != nil {
+		t.Fatalf("dropped volatile emit returned %v", err)
+	}
+
+	c.setAliveValue(true)
+	lost := newLosableConn()
+	close(lost.lost)
+	c.conn = lost
+	if err := c.Volatile().Emit("cursor", 2); err != nil {
+		t.Fatalf("dropped volatile emit returned %v", err)
+	}
+
+	if got := queued(c); len(got) != 0 {
+		t.Fatalf("queued %q while disconnected", got)
+	}
+	if n := metrics.counter(MetricVolatileDropped); n != 2 {
+		t.Fatalf("volatile dropped = %v, want 2", n)
+	}
+}
+
+func TestVolatileEmitDroppedAboveThreshold(t *testing.T) {
+	metrics := &counterMetrics{}
+	c := newTestClient(ConConf{QueueSize: 4, VolatileThreshold: 1, Metrics: metrics})
+	for i := 0; i < 3; i++ {
+		if err := c.Volatile().Emit("cursor", i); err != nil {
+			t.Fatalf("volatile emit %d returned %v", i, err)
+		}
+	}
+	if got := queued(c); len(got) != 2 {
+		t.Fatalf("queued %q, want the two emits under the threshold", got)
+	}
+	if n := metrics.counter(MetricVolatileDropped); n != 1 {
+		t.Fatalf("volatile dropped = %v, want 1", n)
+	}
+
+	// regular emits still use the whole queue
+	for i := 0; i < 4; i++ {
+		if err := c.Emit("event", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}